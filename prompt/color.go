@@ -0,0 +1,242 @@
+package prompt
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Color represents either one of the 256 colors in the xterm palette or a 24-bit
+// RGB color. Palette colors are stored as their index, from 0 to 255, and RGB
+// colors are stored with the colorRGB bit set, followed by eight bits for each
+// one of the red, green and blue channels. A negative value means no color.
+type Color int
+
+// colorRGB is set in the colors that were defined with the #RRGGBB notation.
+const colorRGB Color = 1 << 24
+
+// ColorDepth is the number of colors supported by the terminal emulator.
+type ColorDepth int
+
+const (
+	Depth16 ColorDepth = iota
+	Depth256
+	DepthTrueColor
+)
+
+// colorNames are the names of the first sixteen colors in the xterm palette.
+var colorNames = map[string]Color{
+	"black":         0,
+	"red":           1,
+	"green":         2,
+	"yellow":        3,
+	"blue":          4,
+	"magenta":       5,
+	"cyan":          6,
+	"white":         7,
+	"brightblack":   8,
+	"brightred":     9,
+	"brightgreen":   10,
+	"brightyellow":  11,
+	"brightblue":    12,
+	"brightmagenta": 13,
+	"brightcyan":    14,
+	"brightwhite":   15,
+}
+
+// ansiColors are the RGB values of the first sixteen colors in the xterm palette.
+var ansiColors = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the intensities of each channel in the 6x6x6 color cube that
+// occupies the indexes from 16 to 231 in the xterm palette.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// ColorVar defines a flag with the specified name, default value, and usage
+// string, same as flag.IntVar, but the value can also be a #RRGGBB color or
+// one of the names in the xterm palette, e.g. -user.bg="#005fff"
+func ColorVar(p *Color, name string, value Color, usage string) {
+	*p = value
+	flag.Var(p, name, usage)
+}
+
+// ParseColor converts a palette index, a color name or a #RRGGBB notation into
+// a color.
+func ParseColor(s string) (Color, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := colorNames[s]; ok {
+		return c, nil
+	}
+	if strings.HasPrefix(s, "#") {
+		if len(s) != 7 {
+			return -1, fmt.Errorf("invalid color %q; use the #RRGGBB notation", s)
+		}
+		rgb, err := strconv.ParseUint(s[1:], 16, 32)
+		if err != nil {
+			return -1, fmt.Errorf("invalid color %q; use the #RRGGBB notation", s)
+		}
+		return colorRGB | Color(rgb), nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 || n > 255 {
+		return -1, fmt.Errorf("invalid color %q; use a number between 0 and 255, a color name, or #RRGGBB", s)
+	}
+	return Color(n), nil
+}
+
+// Set implements the flag.Value interface.
+func (c *Color) Set(s string) error {
+	color, err := ParseColor(s)
+	if err != nil {
+		return err
+	}
+	*c = color
+	return nil
+}
+
+// String implements the flag.Value interface.
+func (c Color) String() string {
+	if c.isRGB() {
+		return fmt.Sprintf("#%06x", int(c&0xffffff))
+	}
+	return strconv.Itoa(int(c))
+}
+
+// UnmarshalJSON accepts either a JSON number or a JSON string.
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		return c.Set(strconv.Itoa(n))
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid color %s; use a number or a string", data)
+	}
+	return c.Set(s)
+}
+
+// MarshalJSON returns the color as a JSON string, e.g. "33" or "#ff8000", so
+// UnmarshalJSON reads the same color back.
+func (c Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// isRGB returns true if the color was defined with the #RRGGBB notation.
+func (c Color) isRGB() bool {
+	return c >= 0 && c&colorRGB != 0
+}
+
+// rgb returns the red, green and blue channels of the color.
+func (c Color) rgb() (int, int, int) {
+	return int(c>>16) & 0xff, int(c>>8) & 0xff, int(c) & 0xff
+}
+
+// sgr returns the Select Graphic Rendition parameters that set the color as the
+// foreground, or the background, in a terminal with the specified color depth.
+// Palette colors are always printed as is; RGB colors are converted to the
+// nearest palette color if the terminal does not support 24-bit colors.
+func (c Color) sgr(background bool, depth ColorDepth) string {
+	prefix := "38;"
+	if background {
+		prefix = "48;"
+	}
+	if !c.isRGB() {
+		return prefix + fmt.Sprintf("5;%03d", int(c))
+	}
+	r, g, b := c.rgb()
+	switch depth {
+	case DepthTrueColor:
+		return prefix + fmt.Sprintf("2;%d;%d;%d", r, g, b)
+	case Depth256:
+		return prefix + fmt.Sprintf("5;%03d", nearest256(r, g, b))
+	}
+	// Use the standard sequences, e.g. 31 (red), 41 (red background), 91
+	// (bright red) or 101 (bright red background) for maximum compatibility.
+	n := nearest16(r, g, b)
+	base := 30
+	if background {
+		base = 40
+	}
+	if n >= 8 {
+		base += 60
+		n -= 8
+	}
+	return strconv.Itoa(base + n)
+}
+
+// distance returns the squared euclidean distance between two RGB colors.
+func distance(r1, g1, b1, r2, g2, b2 int) int {
+	return (r1-r2)*(r1-r2) + (g1-g2)*(g1-g2) + (b1-b2)*(b1-b2)
+}
+
+// nearest16 returns the index of the closest color among the first sixteen
+// colors in the xterm palette.
+func nearest16(r, g, b int) int {
+	best := 0
+	for i, c := range ansiColors {
+		if distance(r, g, b, c[0], c[1], c[2]) < distance(r, g, b, ansiColors[best][0], ansiColors[best][1], ansiColors[best][2]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// nearest256 returns the index of the closest color in the color cube or the
+// grayscale ramp of the xterm palette.
+func nearest256(r, g, b int) int {
+	level := func(v int) int {
+		best := 0
+		for i, l := range cubeLevels {
+			if abs(v-l) < abs(v-cubeLevels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	ri, gi, bi := level(r), level(g), level(b)
+	cube := 16 + 36*ri + 6*gi + bi
+	cubeDistance := distance(r, g, b, cubeLevels[ri], cubeLevels[gi], cubeLevels[bi])
+	// The grayscale ramp goes from 8 to 238 in steps of 10.
+	gray := min(max((r+g+b)/3-3, 0)/10, 23)
+	v := 8 + gray*10
+	if distance(r, g, b, v, v, v) < cubeDistance {
+		return 232 + gray
+	}
+	return cube
+}
+
+// abs returns the absolute value of the integer.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// colorDepthFor returns the color depth with the given name, or detects the
+// color depth from the environment variables if the name is "auto".
+func colorDepthFor(name string) ColorDepth {
+	switch name {
+	case "truecolor", "24bit":
+		return DepthTrueColor
+	case "256":
+		return Depth256
+	case "16":
+		return Depth16
+	}
+	colorterm := os.Getenv("COLORTERM")
+	if colorterm == "truecolor" || colorterm == "24bit" {
+		return DepthTrueColor
+	}
+	if strings.Contains(os.Getenv("TERM"), "256") {
+		return Depth256
+	}
+	return Depth16
+}
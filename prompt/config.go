@@ -0,0 +1,455 @@
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config represents all the available program options.
+type Config struct {
+	Debug            bool            `json:"debug"`
+	TimeOn           bool            `json:"time.on"`
+	TimeFg           Color           `json:"time.fg"`
+	TimeBg           Color           `json:"time.bg"`
+	TimeFmt          string          `json:"time.fmt"`
+	UserOn           bool            `json:"user.on"`
+	UserFg           Color           `json:"user.fg"`
+	UserBg           Color           `json:"user.bg"`
+	HostOn           bool            `json:"host.on"`
+	HostFg           Color           `json:"host.fg"`
+	HostBg           Color           `json:"host.bg"`
+	HomeFg           Color           `json:"home.fg"`
+	HomeBg           Color           `json:"home.bg"`
+	RodirFg          Color           `json:"rodir.fg"`
+	RodirBg          Color           `json:"rodir.bg"`
+	CwdN             int             `json:"cwd.n"`
+	CwdOn            bool            `json:"cwd.on"`
+	CwdFg            Color           `json:"cwd.fg"`
+	CwdBg            Color           `json:"cwd.bg"`
+	RepoOn           bool            `json:"repo.on"`
+	RepoFg           Color           `json:"repo.fg"`
+	RepoBg           Color           `json:"repo.bg"`
+	RepoStaged       Color           `json:"repo.staged"`
+	RepoUnstaged     Color           `json:"repo.unstaged"`
+	RepoUntracked    Color           `json:"repo.untracked"`
+	RepoConflicts    Color           `json:"repo.conflicts"`
+	RepoRenamed      Color           `json:"repo.renamed"`
+	RepoStashed      Color           `json:"repo.stashed"`
+	RepoClean        Color           `json:"repo.clean"`
+	RepoDirty        Color           `json:"repo.dirty"`
+	RepoAhead        Color           `json:"repo.ahead"`
+	RepoBehind       Color           `json:"repo.behind"`
+	RepoDiverged     Color           `json:"repo.diverged"`
+	RepoConflicted   Color           `json:"repo.conflicted"`
+	RepoExclude      FlagStringArray `json:"repo.exclude"`
+	RepoInclude      FlagStringArray `json:"repo.include"`
+	RepoCache        time.Duration   `json:"repo.cache"`
+	Plugins          FlagPluginArray `json:"plugin"`
+	PluginFg         Color           `json:"plugin.fg"`
+	PluginBg         Color           `json:"plugin.bg"`
+	PluginTimeout    time.Duration   `json:"plugin.timeout"`
+	PluginStale      time.Duration   `json:"plugin.stale"`
+	PluginCache      time.Duration   `json:"plugin.cache"`
+	PluginCachePwd   bool            `json:"plugin.cache.pwd"`
+	SymbolRoot       string          `json:"symbol.root"`
+	SymbolUser       string          `json:"symbol.user"`
+	JobsN            int             `json:"jobs.n"`
+	JobsFg           Color           `json:"jobs.fg"`
+	JobsBg           Color           `json:"jobs.bg"`
+	DurationTime     time.Duration   `json:"duration.time"`
+	DurationMin      time.Duration   `json:"duration.min"`
+	DurationFg       Color           `json:"duration.fg"`
+	DurationBg       Color           `json:"duration.bg"`
+	StatusFg         Color           `json:"status.fg"`
+	StatusCode       int             `json:"status.code"`
+	StatusPipe       string          `json:"status.pipe"`
+	StatusSuccess    Color           `json:"status.success"`
+	StatusError      Color           `json:"status.error"`
+	StatusMisuse     Color           `json:"status.misuse"`
+	StatusCantExec   Color           `json:"status.cantexec"`
+	StatusNotFound   Color           `json:"status.notfound"`
+	StatusInvalid    Color           `json:"status.invalid"`
+	StatusErrSignal  Color           `json:"status.errsignal"`
+	StatusTerminated Color           `json:"status.terminated"`
+	StatusOutofrange Color           `json:"status.outofrange"`
+	DaemonOn         bool            `json:"daemon.on"`
+	Segments         string          `json:"segments"`
+	Colors           string          `json:"colors"`
+	Plain            bool            `json:"plain"`
+	Shell            string          `json:"shell"`
+	Theme            string          `json:"theme"`
+}
+
+type FlagStringArray []string
+
+// Set appends a value, so the flag can be used multiple times.
+func (v *FlagStringArray) Set(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return fmt.Errorf("empty value")
+	}
+	*v = append(*v, s)
+	return nil
+}
+
+// UnmarshalJSON appends the values of a JSON array of strings.
+func (v *FlagStringArray) UnmarshalJSON(data []byte) error {
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*v = append(*v, values...)
+	return nil
+}
+
+func (v FlagStringArray) String() string {
+	return strings.Join(v, ",")
+}
+
+type FlagPluginArray []Plugin
+
+// Set appends a plugin defined by a command line, or by a JSON object with
+// the command line and the options of the plugin, see pluginOptions.
+func (v *FlagPluginArray) Set(s string) error {
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return v.setOptions([]byte(s))
+	}
+	pieces, err := splitShellWords(s)
+	if err != nil {
+		return fmt.Errorf("invalid plugin %q: %w", s, err)
+	}
+	if len(pieces) == 0 {
+		return fmt.Errorf("invalid plugin")
+	}
+	*v = append(*v, Plugin{
+		Name: pieces[0],
+		Args: pieces[1:],
+	})
+	return nil
+}
+
+// splitShellWords splits the command line into words following the quoting
+// rules of the POSIX shell, without expansions or a shell process:
+//
+//	> date "+%H %M"           → [date, +%H %M]
+//	> printf '%s\n' "a \"b\""  → [printf, %s\n, a "b"]
+//	> ls My\ Documents        → [ls, My Documents]
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, errors.New("unterminated escape")
+			}
+			i++
+			if s[i] != '\n' {
+				// A backslash before a new line joins the lines.
+				word.WriteByte(s[i])
+				inWord = true
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				// Backslashes only escape these characters in double quotes.
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// pluginOptions is the definition of a plugin with options. The options
+// without a value use the values of the -plugin.* flags.
+//
+//	> {"command": "kubectl config current-context", "timeout": "10s", "exists": ".kube"}
+type pluginOptions struct {
+	Command  string `json:"command"`
+	Async    bool   `json:"async"`
+	Timeout  string `json:"timeout"`
+	Fg       *Color `json:"fg"`
+	Bg       *Color `json:"bg"`
+	Cache    string `json:"cache"`
+	CachePwd *bool  `json:"cache.pwd"`
+	Dir      string `json:"dir"`
+	Exists   string `json:"exists"`
+}
+
+// setOptions appends a plugin defined by a JSON object.
+func (v *FlagPluginArray) setOptions(data []byte) error {
+	var opts pluginOptions
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&opts); err != nil {
+		return fmt.Errorf("invalid plugin %s: %w", data, err)
+	}
+	if err := v.Set(opts.Command); err != nil {
+		return err
+	}
+	plugin := &(*v)[len(*v)-1]
+	plugin.Async = opts.Async
+	plugin.Fg, plugin.Bg = opts.Fg, opts.Bg
+	plugin.Exists = opts.Exists
+	plugin.CachePwd = opts.CachePwd
+	plugin.Dir = opts.Dir
+	if rest, ok := strings.CutPrefix(opts.Dir, "~"); ok && (rest == "" || rest[0] == '/') {
+		plugin.Dir = os.Getenv("HOME") + rest
+	}
+	var err error
+	if opts.Timeout != "" {
+		if plugin.Timeout, err = time.ParseDuration(opts.Timeout); err != nil {
+			return fmt.Errorf("invalid plugin timeout: %w", err)
+		}
+	}
+	if opts.Cache != "" {
+		if plugin.Cache, err = time.ParseDuration(opts.Cache); err != nil {
+			return fmt.Errorf("invalid plugin cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON appends the plugins defined in a JSON array of strings or
+// objects with the options of the plugins.
+func (v *FlagPluginArray) UnmarshalJSON(data []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if len(value) > 0 && value[0] == '{' {
+			if err := v.setOptions(value); err != nil {
+				return err
+			}
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return err
+		}
+		if err := v.Set(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v FlagPluginArray) String() string {
+	return ""
+}
+
+type Plugin struct {
+	Name     string
+	Args     []string
+	Async    bool          // prints the cached output and refreshes it in the background.
+	Timeout  time.Duration // overrides -plugin.timeout, if not zero.
+	Fg       *Color        // overrides -plugin.fg, if not nil.
+	Bg       *Color        // overrides -plugin.bg, if not nil.
+	Cache    time.Duration // overrides -plugin.cache, if not zero.
+	CachePwd *bool         // overrides -plugin.cache.pwd, if not nil.
+	Dir      string        // executes the plugin in this folder instead of $PWD.
+	Exists   string        // executes the plugin only if this file exists in $PWD or a parent folder.
+}
+
+// defaultConfigFile returns the location of the configuration file following
+// the XDG Base Directory Specification, e.g. ~/.config/powergoline/config.json
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "powergoline", "config.json")
+}
+
+// cacheDir returns the folder for the files cached by the program, which is
+// $XDG_CACHE_HOME/powergoline or ~/.cache/powergoline.
+func cacheDir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(dir, "powergoline")
+}
+
+// parseFlags loads the configuration file and parses the command line
+// arguments, so the flags override the values in the file. A flag in the
+// command line replaces the value in the file instead of appending to it,
+// e.g. -plugin replaces the plugins defined in the file.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	filename, explicit, set := scanFlags(fs, args)
+	if filename != "" {
+		// Ignore the error if the default configuration file does not exist.
+		if err := loadConfigFile(fs, filename, set); err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+			return err
+		}
+	}
+	return fs.Parse(args)
+}
+
+// scanFlags parses the command line arguments with a copy of the flags that
+// discards the values, and returns the value of the -config flag, true if the
+// flag exists in the arguments, and the variables set by the arguments. The
+// errors are ignored here, fs.Parse reports them.
+func scanFlags(fs *flag.FlagSet, args []string) (string, bool, map[any]bool) {
+	var filename string
+	scan := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	scan.SetOutput(io.Discard)
+	scan.Usage = func() {}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			scan.StringVar(&filename, f.Name, f.DefValue, "")
+			return
+		}
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		scan.Var(discardFlag(ok && b.IsBoolFlag()), f.Name, "")
+	})
+	_ = scan.Parse(args)
+	explicit := false
+	set := map[any]bool{}
+	scan.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "config"
+		set[flagTarget(fs.Lookup(f.Name).Value)] = true
+	})
+	return filename, explicit, set
+}
+
+// discardFlag accepts any value without setting a variable. The value tells
+// whether the flag is a boolean, which takes no argument.
+type discardFlag bool
+
+func (f discardFlag) Set(string) error { return nil }
+
+func (f discardFlag) String() string { return "" }
+
+func (f discardFlag) IsBoolFlag() bool { return bool(f) }
+
+// flagTarget returns the variable set by the flag, so the flags sharing the
+// same variable are overridden together, e.g. -plugin and -plugin.async.
+func flagTarget(v flag.Value) any {
+	if f, ok := v.(pluginAsyncFlag); ok {
+		return f.plugins
+	}
+	return v
+}
+
+// loadConfigFile reads a JSON object from the file and sets the value of each
+// flag with the same name as the keys in the object. For example:
+//
+//	{
+//	  "theme": "wildcherry",
+//	  "cwd.n": 3,
+//	  "repo.on": true,
+//	  "plugin.timeout": "2s",
+//	  "plugin": ["whoami", "date +%H:%M"]
+//	}
+//
+// The keys of the variables in set are skipped, the command line sets them.
+func loadConfigFile(fs *flag.FlagSet, filename string, set map[any]bool) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// Sort the keys to always report the same error first.
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := fs.Lookup(key)
+		if f == nil || key == "config" {
+			return fmt.Errorf("%s: unknown key %q", filename, key)
+		}
+		if set[flagTarget(f.Value)] {
+			continue
+		}
+		if err := setFlagJSON(f, values[key]); err != nil {
+			return fmt.Errorf("%s: invalid value for %q: %w", filename, key, err)
+		}
+	}
+	return nil
+}
+
+// setFlagJSON sets the value of the flag from a JSON value of the same type.
+func setFlagJSON(f *flag.Flag, data json.RawMessage) error {
+	if u, ok := f.Value.(json.Unmarshaler); ok {
+		return u.UnmarshalJSON(data)
+	}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return fmt.Errorf("unsupported flag type %T", f.Value)
+	}
+	var err error
+	var text string
+	switch getter.Get().(type) {
+	case bool:
+		var value bool
+		err = json.Unmarshal(data, &value)
+		text = strconv.FormatBool(value)
+	case int:
+		var value int
+		err = json.Unmarshal(data, &value)
+		text = strconv.Itoa(value)
+	case string, time.Duration:
+		err = json.Unmarshal(data, &text)
+	default:
+		return fmt.Errorf("unsupported flag type %T", f.Value)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Value.Set(text)
+}
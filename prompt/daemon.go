@@ -0,0 +1,407 @@
+package prompt
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errDaemonUnavailable defines an error when the daemon is disabled or is not
+// running, in which case the segments compute their data directly.
+var errDaemonUnavailable = errors.New("daemon unavailable")
+
+// daemonMaxWatches is the maximum number of paths watched per repository.
+// Repositories with more folders are refreshed periodically instead.
+const daemonMaxWatches = 4096
+
+// daemonSocket returns the location of the Unix socket of the daemon, which is
+// $XDG_RUNTIME_DIR/powergoline.sock or a file in the temporary folder that is
+// unique to the current user.
+func daemonSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "powergoline.sock")
+	}
+	return filepath.Join(os.TempDir(), "powergoline-"+strconv.Itoa(os.Getuid())+".sock")
+}
+
+// daemonRequest asks the daemon for the status of a repository or the output
+// of a plugin, one request per connection.
+type daemonRequest struct {
+	Root    string        `json:"root,omitempty"`
+	Kind    string        `json:"kind,omitempty"`
+	Plugin  *Plugin       `json:"plugin,omitempty"`
+	Dir     string        `json:"dir,omitempty"`
+	Env     []string      `json:"env,omitempty"`
+	Environ []string      `json:"environ,omitempty"` // environment of the shell.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// daemonResponse is the answer of the daemon to a request.
+type daemonResponse struct {
+	Status RepoStatus `json:"status"`
+	Output []byte     `json:"output,omitempty"`
+	Empty  bool       `json:"empty,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// err returns the error of the response, if any.
+func (r daemonResponse) err() error {
+	if r.Empty {
+		return errEmptyOutput
+	}
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	return nil
+}
+
+// askDaemon sends the request to the daemon and waits for the answer. It
+// returns errDaemonUnavailable if the daemon is disabled or not running.
+func askDaemon(config Config, req daemonRequest) (daemonResponse, error) {
+	var res daemonResponse
+	if !config.DaemonOn {
+		return res, errDaemonUnavailable
+	}
+	conn, err := net.DialTimeout("unix", daemonSocket(), 50*time.Millisecond)
+	if err != nil {
+		return res, errDaemonUnavailable
+	}
+	defer conn.Close()
+	// The first request for a repository or a plugin is computed on demand.
+	_ = conn.SetDeadline(time.Now().Add(max(req.Timeout, defaultPluginTimeout) + time.Second))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return res, err
+	}
+	err = json.NewDecoder(conn).Decode(&res)
+	return res, err
+}
+
+// daemon keeps the status of the repositories and the output of the plugins
+// in memory. Repositories are refreshed when the files in the worktree change,
+// and plugins are executed again once their output is older than the interval.
+type daemon struct {
+	mu       sync.Mutex
+	repos    map[string]*daemonEntry
+	plugins  map[string]*daemonEntry
+	watcher  *repoWatcher
+	interval time.Duration
+	idle     time.Duration
+}
+
+// daemonEntry is one cached repository status or plugin output.
+type daemonEntry struct {
+	res     daemonResponse
+	req     daemonRequest
+	updated time.Time
+	used    time.Time
+	stale   bool
+	watched bool
+	done    chan struct{}
+}
+
+// runDaemon parses the arguments of the `powergoline daemon` command and
+// serves the requests until the program receives SIGINT or SIGTERM.
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	socket := fs.String("socket", daemonSocket(), "Defines the location of the Unix socket")
+	interval := fs.Duration("interval", time.Second*10, "Time between plugin executions, and between repository\nrefreshes if the folders cannot be watched")
+	idle := fs.Duration("idle", time.Minute*10, "Forgets the repositories and plugins not used for this long")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("daemon already running on %s", *socket)
+	}
+	// Remove the socket left behind by a daemon that did not exit cleanly.
+	_ = os.Remove(*socket)
+	ln, err := net.Listen("unix", *socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(*socket, 0o600); err != nil {
+		ln.Close()
+		return err
+	}
+	d := &daemon{
+		repos:    map[string]*daemonEntry{},
+		plugins:  map[string]*daemonEntry{},
+		interval: *interval,
+		idle:     *idle,
+	}
+	if d.watcher, err = newRepoWatcher(d.invalidate); err != nil {
+		fmt.Fprintln(os.Stderr, "cannot watch folders:", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		ln.Close()
+	}()
+	go d.schedule()
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go d.serve(conn)
+	}
+}
+
+// serve answers one request.
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var req daemonRequest
+	var res daemonResponse
+	if err := json.Unmarshal(line, &req); err != nil {
+		res.Error = err.Error()
+	} else if req.Plugin != nil {
+		res = d.plugin(req)
+	} else {
+		res = d.repo(req)
+	}
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	_ = json.NewEncoder(conn).Encode(res)
+}
+
+// repo returns the status of a repository, waiting for the refresh if the
+// status is unknown or the files changed since the last refresh.
+func (d *daemon) repo(req daemonRequest) daemonResponse {
+	if repoBackendFor(req.Kind) == nil {
+		return daemonResponse{Error: "unknown repository kind " + req.Kind}
+	}
+	d.mu.Lock()
+	entry, ok := d.repos[req.Root]
+	if !ok || entry.req.Kind != req.Kind {
+		entry = &daemonEntry{req: req, stale: true}
+		d.repos[req.Root] = entry
+		if d.watcher != nil {
+			go func() {
+				watched := d.watcher.Add(req.Root)
+				d.mu.Lock()
+				entry.watched = watched
+				d.mu.Unlock()
+			}()
+		}
+	}
+	entry.used = time.Now()
+	if entry.stale || (!entry.watched && time.Since(entry.updated) > d.interval) {
+		d.refreshRepo(entry)
+	}
+	done := entry.done
+	d.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return entry.res
+}
+
+// refreshRepo computes the status of the repository in the background. The
+// caller must hold the lock.
+func (d *daemon) refreshRepo(entry *daemonEntry) {
+	if entry.done != nil {
+		return
+	}
+	entry.stale = false
+	entry.done = make(chan struct{})
+	go func() {
+		var res daemonResponse
+		status, err := repoBackendFor(entry.req.Kind).Status(entry.req.Root)
+		res.Status = status
+		if err != nil {
+			res.Error = err.Error()
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		entry.res, entry.updated = res, time.Now()
+		close(entry.done)
+		entry.done = nil
+		if entry.stale {
+			// The files changed again during the refresh.
+			d.refreshRepo(entry)
+		}
+	}()
+}
+
+// invalidate marks the status of the repository as stale and refreshes it in
+// the background, so the next request is likely answered from memory. The
+// repository is refreshed periodically instead if the watcher lost one of
+// the files, e.g. the index was deleted.
+func (d *daemon) invalidate(root string, watched bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, ok := d.repos[root]; ok {
+		entry.watched = entry.watched && watched
+		entry.stale = true
+		d.refreshRepo(entry)
+	}
+}
+
+// daemonPluginKey identifies the output of a plugin. The environment is part
+// of the key, so the output always describes the current state of the prompt,
+// e.g. POWERGOLINE_STATUS, and of the shell, e.g. KUBECONFIG, and the plugin
+// is executed on demand once either changes.
+func daemonPluginKey(req daemonRequest) string {
+	return req.Dir + "\x00" + req.Plugin.Name + "\x00" + strings.Join(req.Plugin.Args, "\x00") + "\x00\x00" + strings.Join(req.Env, "\x00") + "\x00\x00" + strings.Join(req.Environ, "\x00")
+}
+
+// plugin returns the last output of a plugin, executing the plugin first if
+// this is the first request with this environment.
+func (d *daemon) plugin(req daemonRequest) daemonResponse {
+	key := daemonPluginKey(req)
+	d.mu.Lock()
+	entry, ok := d.plugins[key]
+	if !ok {
+		entry = &daemonEntry{req: req}
+		d.plugins[key] = entry
+		d.refreshPlugin(entry)
+	}
+	entry.used = time.Now()
+	done := entry.done
+	if !entry.updated.IsZero() {
+		// Do not wait for the scheduled executions.
+		done = nil
+	}
+	d.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return entry.res
+}
+
+// refreshPlugin executes the plugin in the background. The caller must hold
+// the lock.
+func (d *daemon) refreshPlugin(entry *daemonEntry) {
+	if entry.done != nil {
+		return
+	}
+	entry.done = make(chan struct{})
+	req := entry.req
+	go func() {
+		var res daemonResponse
+		// Execute the plugin with the environment of the shell instead of
+		// the environment of the daemon.
+		environ := req.Environ
+		if environ == nil {
+			environ = os.Environ()
+		}
+		output, err := callEnv(req.Dir, append(slices.Clip(environ), req.Env...), req.Timeout, req.Plugin.Name, req.Plugin.Args...)
+		res.Output = output
+		if errors.Is(err, errEmptyOutput) {
+			res.Empty = true
+		} else if err != nil {
+			res.Error = err.Error()
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		entry.res, entry.updated = res, time.Now()
+		close(entry.done)
+		entry.done = nil
+	}()
+}
+
+// schedule executes the plugins periodically, refreshes the repositories that
+// are not watched, and forgets the entries that are no longer used.
+func (d *daemon) schedule() {
+	for range time.Tick(time.Second) {
+		d.mu.Lock()
+		for key, entry := range d.plugins {
+			if time.Since(entry.used) > d.idle {
+				delete(d.plugins, key)
+			} else if time.Since(entry.updated) > max(d.interval, entry.req.Plugin.Cache) {
+				d.refreshPlugin(entry)
+			}
+		}
+		for root, entry := range d.repos {
+			if time.Since(entry.used) > d.idle {
+				delete(d.repos, root)
+				if d.watcher != nil {
+					go d.watcher.Remove(root)
+				}
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// repoWatchSkip returns a function that reports whether a folder must not be
+// watched because Git ignores it, e.g. node_modules or target, so builds do
+// not refresh the status of the repository.
+func repoWatchSkip(root string) func(dir string) bool {
+	checker := newGitIgnoreChecker(root)
+	return func(dir string) bool {
+		if checker == nil {
+			return false
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return false
+		}
+		return checker.ignoredDir(filepath.ToSlash(rel))
+	}
+}
+
+// repoWatchPaths returns the folders and files to watch to notice the changes
+// in the repository, or false if there are more than the limit. Only HEAD, the
+// index and the references are watched in the metadata of Git, so the locks
+// and objects written by Git commands do not trigger refreshes. The metadata
+// folders of the other VCS are watched, but not their subfolders.
+func repoWatchPaths(root string, limit int, skip func(dir string) bool) ([]string, bool) {
+	var paths []string
+	if gitdir, err := findGitDir(root); err == nil {
+		commondir := gitCommonDir(gitdir)
+		paths = append(paths, filepath.Join(gitdir, "HEAD"), filepath.Join(gitdir, "index"))
+		_ = filepath.WalkDir(filepath.Join(commondir, "refs"), func(name string, entry fs.DirEntry, err error) error {
+			if err == nil && entry.IsDir() {
+				paths = append(paths, name)
+			}
+			return nil
+		})
+	}
+	_ = filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if len(paths) >= limit {
+			return fs.SkipAll
+		}
+		switch entry.Name() {
+		case ".git":
+			return fs.SkipDir
+		case ".hg", ".svn", ".jj", ".bzr":
+			paths = append(paths, name)
+			return fs.SkipDir
+		}
+		if skip(name) {
+			return fs.SkipDir
+		}
+		paths = append(paths, name)
+		return nil
+	})
+	return paths, len(paths) < limit
+}
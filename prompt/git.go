@@ -0,0 +1,726 @@
+package prompt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errGitUnsupported defines an error when the repository uses a feature that
+// the native Git reader does not support, e.g. SHA-256 object names, split
+// indexes or the reftable backend. The caller is expected to fall back to the
+// `git status` command in this case.
+var errGitUnsupported = errors.New("unsupported git repository")
+
+// gitRepository represents a Git repository on disk. The worktree is the folder
+// with the files checked out by the user, the gitdir is the folder with the
+// HEAD and the index of the worktree, and the commondir is the folder with the
+// objects, the references and the configuration shared by all the worktrees.
+// The gitdir and the commondir are the same folder for the main worktree.
+type gitRepository struct {
+	worktree  string
+	gitdir    string
+	commondir string
+	config    gitConfig
+	objects   *gitObjectStore
+}
+
+// openGitRepository opens the Git repository checked out in the root folder.
+// The .git entry is either a folder or, for worktrees and submodules, a file
+// with the location of the gitdir, e.g. "gitdir: ../.git/modules/foobar".
+func openGitRepository(root string) (*gitRepository, error) {
+	gitdir, err := findGitDir(root)
+	if err != nil {
+		return nil, err
+	}
+	repo := &gitRepository{worktree: root, gitdir: gitdir, commondir: gitCommonDir(gitdir)}
+	config, err := readGitConfig(filepath.Join(repo.commondir, "config"))
+	if err != nil {
+		return nil, err
+	}
+	repo.config = config
+	if config.Get("core.repositoryformatversion") == "1" {
+		// Repositories with extensions may use SHA-256 object names or store
+		// the references in a reftable, neither of which is supported.
+		for key := range config {
+			if strings.HasPrefix(key, "extensions.") && key != "extensions.worktreeconfig" {
+				return nil, errGitUnsupported
+			}
+		}
+	}
+	repo.objects = newGitObjectStore(filepath.Join(repo.commondir, "objects"))
+	return repo, nil
+}
+
+// findGitDir returns the location of the gitdir of the worktree in the root
+// folder, following the gitfile used by worktrees and submodules.
+func findGitDir(root string) (string, error) {
+	gitdir := filepath.Join(root, ".git")
+	info, err := os.Stat(gitdir)
+	if err != nil || info.IsDir() {
+		return gitdir, err
+	}
+	data, err := os.ReadFile(gitdir)
+	if err != nil {
+		return "", err
+	}
+	target, ok := bytes.CutPrefix(bytes.TrimSpace(data), []byte("gitdir: "))
+	if !ok {
+		return "", fmt.Errorf("invalid gitfile %s", gitdir)
+	}
+	if !filepath.IsAbs(string(target)) {
+		return filepath.Join(root, string(target)), nil
+	}
+	return string(target), nil
+}
+
+// gitCommonDir returns the location of the commondir of the gitdir, which is
+// a different folder for linked worktrees.
+func gitCommonDir(gitdir string) string {
+	data, err := os.ReadFile(filepath.Join(gitdir, "commondir"))
+	if err != nil {
+		return gitdir
+	}
+	if commondir := string(bytes.TrimSpace(data)); filepath.IsAbs(commondir) {
+		return commondir
+	} else {
+		return filepath.Join(gitdir, commondir)
+	}
+}
+
+// gitConfig holds the variables in a Git configuration file. The keys are the
+// section, the optional subsection and the variable name separated by periods,
+// e.g. "branch.main.remote", with the section and the name in lowercase.
+type gitConfig map[string]string
+
+// Get returns the value of a variable or an empty string.
+func (c gitConfig) Get(key string) string {
+	return c[key]
+}
+
+// readGitConfig parses a Git configuration file. Only the syntax used by Git
+// itself to write the files is supported; include directives are ignored.
+//
+//	> [core]
+//	>     bare = false
+//	> [branch "main"]
+//	>     remote = origin
+//	>     merge = refs/heads/main
+func readGitConfig(filename string) (gitConfig, error) {
+	config := gitConfig{}
+	file, err := os.Open(filename)
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+	var section string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.LastIndexByte(line, ']')
+			if end == -1 {
+				continue
+			}
+			name, sub, ok := strings.Cut(line[1:end], " ")
+			if ok {
+				section = strings.ToLower(name) + "." + strings.Trim(strings.TrimSpace(sub), "\"")
+			} else {
+				section = strings.ToLower(name)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			// A variable without a value is a boolean true.
+			value = "true"
+		}
+		if i := strings.IndexAny(value, "#;"); i != -1 && !strings.Contains(value[:i], "\"") {
+			value = value[:i]
+		}
+		value = strings.Trim(strings.TrimSpace(value), "\"")
+		config[section+"."+strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return config, scanner.Err()
+}
+
+// readRef returns the content of a reference, which is either an object name
+// or a symbolic reference like "ref: refs/heads/main". Per-worktree references
+// like HEAD are read from the gitdir, and the rest from the commondir.
+func (r *gitRepository) readRef(name string) (string, error) {
+	dir := r.commondir
+	if name == "HEAD" || strings.HasPrefix(name, "refs/bisect/") || strings.HasPrefix(name, "refs/worktree/") {
+		dir = r.gitdir
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	return r.readPackedRef(name)
+}
+
+// readPackedRef finds a reference in the packed-refs file.
+//
+//	> # pack-refs with: peeled fully-peeled sorted
+//	> 2f3f5e4e4cc4ee1c7b95b1e1d1dfb7bc1cc5d5d6 refs/heads/main
+//	> 8b1c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c refs/tags/v1.0.0
+//	> ^2f3f5e4e4cc4ee1c7b95b1e1d1dfb7bc1cc5d5d6
+func (r *gitRepository) readPackedRef(name string) (string, error) {
+	file, err := os.Open(filepath.Join(r.commondir, "packed-refs"))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		if oid, ref, ok := strings.Cut(line, " "); ok && ref == name {
+			return oid, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", os.ErrNotExist
+}
+
+// resolveRef follows the symbolic references until it finds an object name.
+// It returns the name of the last reference in the chain, which for HEAD is
+// usually the current branch, e.g. refs/heads/main.
+func (r *gitRepository) resolveRef(name string) (string, gitHash, error) {
+	for range 5 {
+		value, err := r.readRef(name)
+		if err != nil {
+			return name, gitHash{}, err
+		}
+		if target, ok := strings.CutPrefix(value, "ref: "); ok {
+			name = target
+			continue
+		}
+		oid, err := parseGitHash(value)
+		return name, oid, err
+	}
+	return name, gitHash{}, fmt.Errorf("too many levels of symbolic references for %s", name)
+}
+
+// upstreamRef returns the name of the remote-tracking branch of a local branch,
+// e.g. refs/remotes/origin/main, or an empty string if there is none.
+func (r *gitRepository) upstreamRef(branch string) string {
+	remote := r.config.Get("branch." + branch + ".remote")
+	merge := r.config.Get("branch." + branch + ".merge")
+	if remote == "" || merge == "" {
+		return ""
+	}
+	if remote == "." {
+		// The upstream is another local branch.
+		return merge
+	}
+	name, ok := strings.CutPrefix(merge, "refs/heads/")
+	if !ok {
+		return ""
+	}
+	return "refs/remotes/" + remote + "/" + name
+}
+
+// repoStatusGitNative returns information about the current state of a Git
+// repository by reading the files in the .git folder, without the overhead of
+// executing the `git status` command.
+func repoStatusGitNative(root string) (RepoStatus, error) {
+	var status RepoStatus
+	repo, err := openGitRepository(root)
+	if err != nil {
+		return status, err
+	}
+	branch, head, onBranch, err := repo.branch()
+	if err != nil {
+		return status, err
+	}
+	status.Branch = []byte(branch)
+	status.Operation, _ = gitOperation(repo.gitdir)
+	if onBranch && !head.IsZero() {
+		if upstream := repo.upstreamRef(branch); upstream != "" {
+			if _, remote, err := repo.resolveRef(upstream); err == nil {
+				if status.Ahead, status.Behind, err = repo.aheadBehind(head, remote); err != nil {
+					return status, err
+				}
+			}
+		}
+	}
+	index, err := readGitIndex(filepath.Join(repo.gitdir, "index"))
+	if err != nil {
+		return status, err
+	}
+	changes, err := repo.diff(head, index)
+	if err != nil {
+		return status, err
+	}
+	for _, change := range changes {
+		repoStatusGitCount(&status, change.X, change.Y)
+	}
+	status.Stashed = readGitStash(repo.commondir)
+	return status, nil
+}
+
+// branch returns the name of the current branch and the commit at HEAD. If
+// HEAD is detached, the name is a tag or an abbreviated object name and the
+// third value is false.
+func (r *gitRepository) branch() (string, gitHash, bool, error) {
+	ref, head, err := r.resolveRef("HEAD")
+	if err != nil && !os.IsNotExist(err) {
+		return "", head, false, err
+	}
+	if _, rebasing := gitOperation(r.gitdir); rebasing != "" {
+		// HEAD is detached during a rebase; use the branch being rebased.
+		return rebasing, head, false, nil
+	}
+	if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return branch, head, true, nil
+	}
+	return r.describe(head), head, false, nil
+}
+
+// describe returns the name of a tag that points to the commit or, if there
+// is none, the abbreviated object name, for example when HEAD is detached.
+func (r *gitRepository) describe(oid gitHash) string {
+	if oid.IsZero() {
+		return "HEAD (no branch)"
+	}
+	var tags []string
+	refs := filepath.Join(r.commondir, "refs", "tags")
+	_ = filepath.WalkDir(refs, func(filename string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if name, err := filepath.Rel(refs, filename); err == nil {
+			tags = append(tags, filepath.ToSlash(name))
+		}
+		return nil
+	})
+	if file, err := os.Open(filepath.Join(r.commondir, "packed-refs")); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if _, name, ok := strings.Cut(scanner.Text(), " refs/tags/"); ok {
+				tags = append(tags, name)
+			}
+		}
+		file.Close()
+	}
+	sort.Strings(tags)
+	for _, name := range tags {
+		if _, target, err := r.resolveRef("refs/tags/" + name); err == nil && r.peel(target) == oid {
+			return name
+		}
+	}
+	return oid.String()[:7]
+}
+
+// peel follows annotated tags until it finds an object that is not a tag.
+func (r *gitRepository) peel(oid gitHash) gitHash {
+	for range 5 {
+		typ, data, err := r.objects.readObject(oid)
+		if err != nil || typ != gitObjectTag {
+			return oid
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		target, ok := strings.CutPrefix(line, "object ")
+		if !ok {
+			return oid
+		}
+		if oid, err = parseGitHash(target); err != nil {
+			return oid
+		}
+	}
+	return oid
+}
+
+// gitOperation returns the name of the operation in progress in the worktree,
+// if any, same as the prompt script distributed with Git. Rebases include the
+// current step, e.g. "REBASE 3/7", and the name of the branch being rebased.
+func gitOperation(gitdir string) (string, string) {
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(gitdir, name))
+		return strings.TrimSpace(string(data))
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(gitdir, name))
+		return err == nil
+	}
+	if exists("rebase-merge") {
+		branch := strings.TrimPrefix(read("rebase-merge/head-name"), "refs/heads/")
+		return gitOperationStep("REBASE", read("rebase-merge/msgnum"), read("rebase-merge/end")), branch
+	}
+	if exists("rebase-apply") {
+		operation := "AM/REBASE"
+		if exists("rebase-apply/rebasing") {
+			operation = "REBASE"
+		} else if exists("rebase-apply/applying") {
+			operation = "AM"
+		}
+		branch := strings.TrimPrefix(read("rebase-apply/head-name"), "refs/heads/")
+		return gitOperationStep(operation, read("rebase-apply/next"), read("rebase-apply/last")), branch
+	}
+	switch {
+	case exists("MERGE_HEAD"):
+		return "MERGING", ""
+	case exists("CHERRY_PICK_HEAD"):
+		return "CHERRY-PICKING", ""
+	case exists("REVERT_HEAD"):
+		return "REVERTING", ""
+	case exists("BISECT_LOG"):
+		return "BISECTING", ""
+	}
+	return "", ""
+}
+
+// gitOperationStep appends the step to the name of the operation, if known.
+func gitOperationStep(operation string, step string, total string) string {
+	if step == "" || total == "" {
+		return operation
+	}
+	return operation + " " + step + "/" + total
+}
+
+// readGitStash returns the number of entries in the stash, which are stored as
+// the reflog of the refs/stash reference, one line per entry, newest last.
+func readGitStash(commondir string) int {
+	data, err := os.ReadFile(filepath.Join(commondir, "logs", "refs", "stash"))
+	if err != nil {
+		return 0
+	}
+	return bytes.Count(data, []byte{'\n'})
+}
+
+// aheadBehind counts the commits that are reachable from the local commit but
+// not from the remote commit, and vice versa, same as the command `git rev-list
+// --left-right --count local...remote`. Commits are visited from newest to
+// oldest, and the walk does not go past the commits that are reachable from
+// both sides, because the rest of the history is shared.
+func (r *gitRepository) aheadBehind(local gitHash, remote gitHash) (int, int, error) {
+	const (
+		fromLocal  = 1
+		fromRemote = 2
+		fromBoth   = fromLocal | fromRemote
+	)
+	if local == remote {
+		return 0, 0, nil
+	}
+	flags := map[gitHash]int{local: fromLocal, remote: fromRemote}
+	parents := map[gitHash][]gitHash{}
+	// mark adds the flags to a commit and, if the commit was already visited,
+	// to its ancestors too, in case the commit dates are not in order.
+	mark := func(oid gitHash, flag int) {
+		stack := []gitHash{oid}
+		for len(stack) > 0 {
+			oid = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if flags[oid]|flag == flags[oid] {
+				continue
+			}
+			flags[oid] |= flag
+			stack = append(stack, parents[oid]...)
+		}
+	}
+	shallow := r.shallowCommits()
+	var queue gitCommitQueue
+	for _, oid := range []gitHash{local, remote} {
+		commit, err := r.objects.readCommit(oid)
+		if err != nil {
+			return 0, 0, err
+		}
+		queue.Push(commit)
+	}
+	// The parents of the commits reachable from both sides are read too, so
+	// their ancestors are not counted as behind or ahead; the walk stops once
+	// every commit in the queue is reachable from both sides.
+	interesting := func() bool {
+		for _, commit := range queue {
+			if flags[commit.OID] != fromBoth {
+				return true
+			}
+		}
+		return false
+	}
+	for queue.Len() > 0 && interesting() {
+		commit := queue.Pop()
+		if shallow[commit.OID] {
+			// The parents of the commit were not fetched.
+			commit.Parents = nil
+		}
+		parents[commit.OID] = commit.Parents
+		for _, parent := range commit.Parents {
+			_, seen := flags[parent]
+			mark(parent, flags[commit.OID])
+			if seen {
+				continue
+			}
+			next, err := r.objects.readCommit(parent)
+			if err != nil {
+				return 0, 0, err
+			}
+			queue.Push(next)
+		}
+	}
+	var ahead, behind int
+	for _, flag := range flags {
+		switch flag {
+		case fromLocal:
+			ahead++
+		case fromRemote:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// shallowCommits returns the boundary commits of a shallow clone, listed in
+// the .git/shallow file, whose parents are missing from the object database.
+func (r *gitRepository) shallowCommits() map[gitHash]bool {
+	data, err := os.ReadFile(filepath.Join(r.commondir, "shallow"))
+	if err != nil {
+		return nil
+	}
+	shallow := map[gitHash]bool{}
+	for _, line := range strings.Fields(string(data)) {
+		if oid, err := parseGitHash(line); err == nil {
+			shallow[oid] = true
+		}
+	}
+	return shallow
+}
+
+// gitCommitQueue is a list of commits sorted by commit date, newest last.
+type gitCommitQueue []gitCommit
+
+// Len returns the number of commits in the queue.
+func (q gitCommitQueue) Len() int {
+	return len(q)
+}
+
+// Push inserts the commit in the queue, keeping the list sorted by date.
+func (q *gitCommitQueue) Push(commit gitCommit) {
+	i := len(*q)
+	for i > 0 && (*q)[i-1].Time > commit.Time {
+		i--
+	}
+	*q = append(*q, gitCommit{})
+	copy((*q)[i+1:], (*q)[i:])
+	(*q)[i] = commit
+}
+
+// Pop removes and returns the newest commit in the queue.
+func (q *gitCommitQueue) Pop() gitCommit {
+	commit := (*q)[len(*q)-1]
+	*q = (*q)[:len(*q)-1]
+	return commit
+}
+
+// gitBackend is the RepoBackend for Git repositories.
+type gitBackend struct{}
+
+// Detect returns true if the folder has a .git folder, or a .git file in the
+// case of worktrees and submodules.
+func (gitBackend) Detect(dir string) bool {
+	return fileExists(filepath.Join(dir, ".git"))
+}
+
+// Status returns the state of the Git repository.
+func (gitBackend) Status(root string) (RepoStatus, error) {
+	return repoStatusGit(root)
+}
+
+// Branch returns the current branch of the Git repository.
+func (gitBackend) Branch(root string) (string, error) {
+	if repo, err := openGitRepository(root); err == nil {
+		if branch, _, _, err := repo.branch(); err == nil {
+			return branch, nil
+		}
+	}
+	out, err := call(defaultPluginTimeout, "git", "-C", root, "rev-parse", "--abbrev-ref", "HEAD")
+	return string(out), err
+}
+
+// repoStatusGit returns information about the current state of a Git repository.
+// The files in the .git folder are read directly, which is much faster than the
+// `git status` command, and the command is only executed if the repository
+// uses a feature that is not supported by the native reader.
+func repoStatusGit(root string) (RepoStatus, error) {
+	status, err := repoStatusGitNative(root)
+
+	if !errors.Is(err, errGitUnsupported) {
+		return status, err
+	}
+
+	// Do not refresh the index, which would notify the watchers of the daemon.
+	out, err := call(defaultPluginTimeout, "git", "-C", root, "--no-optional-locks", "status", "--branch", "--porcelain", "--ignore-submodules")
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	status, err = repoStatusGitParse(bytes.Split(out, []byte("\n")))
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	if gitdir, err := findGitDir(root); err == nil {
+		// The porcelain format does not include the stash nor the operation.
+		status.Stashed = readGitStash(gitCommonDir(gitdir))
+		operation, rebasing := gitOperation(gitdir)
+		status.Operation = operation
+		if rebasing != "" {
+			status.Branch = []byte(rebasing)
+		}
+	}
+
+	if bytes.Equal(status.Branch, []byte("HEAD (no branch)")) {
+		if tag, err := call(defaultPluginTimeout, "git", "-C", root, "describe", "--tags", "--exact-match", "HEAD"); err == nil {
+			status.Branch = bytes.TrimSpace(tag)
+		} else if oid, err := call(defaultPluginTimeout, "git", "-C", root, "rev-parse", "--short", "HEAD"); err == nil {
+			status.Branch = bytes.TrimSpace(oid)
+		}
+	}
+
+	return status, nil
+}
+
+// repoStatusGitParse parses the output of the `git status` command.
+//
+//	> ## master...origin/master [ahead 5, behind 8]
+//	> D  deleted.txt
+//	>  D missing.txt
+//	> M  patches.go
+//	>  M changes.go
+//	> A  newfile.sh
+//	> R  oldname.go -> newname.go
+//	> UU conflict.go
+//	> ?? isadded.json
+func repoStatusGitParse(lines [][]byte) (RepoStatus, error) {
+	var status RepoStatus
+
+	for _, line := range lines {
+		if len(line) < 4 {
+			continue
+		}
+
+		if line[0] == '#' && line[1] == '#' {
+			repoStatusGitBranch(&status, line)
+			continue
+		}
+
+		repoStatusGitCount(&status, line[0], line[1])
+	}
+
+	return status, nil
+}
+
+// repoStatusGitCount updates the counters with the status of one file, where
+// x is the status of the index and y is the status of the worktree.
+func repoStatusGitCount(status *RepoStatus, x byte, y byte) {
+	if x == '?' || x == '!' {
+		if x == '?' {
+			status.Untracked++
+		}
+		return
+	}
+
+	if x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D') {
+		// Unmerged paths: DD, AU, UD, UA, DU, AA, UU.
+		status.Conflicts++
+		return
+	}
+
+	if x != ' ' {
+		status.Staged++
+	}
+
+	if y != ' ' {
+		status.Unstaged++
+	}
+
+	if x == 'R' {
+		status.Renamed++
+		return
+	}
+
+	if x == 'D' || y == 'D' {
+		status.Deleted++
+		return
+	}
+
+	if x == 'M' || y == 'M' {
+		status.Modified++
+		return
+	}
+
+	if x == 'A' || y == 'A' {
+		status.Added++
+		return
+	}
+}
+
+// repoStatusGitBranch parses the header of the `git status` command.
+//
+//	> ## master
+//	> ## master...origin/master
+//	> ## master...origin/master [ahead 5]
+//	> ## master...origin/master [behind 8]
+//	> ## master...origin/master [ahead 5, behind 8]
+func repoStatusGitBranch(status *RepoStatus, line []byte) {
+	var bols [][]byte
+	var clean []byte
+
+	// add ellipsis to parse branch without origin.
+	line = append(line, []byte{'.', '.', '.'}...)
+
+	if bytes.Contains(line, []byte("...")) {
+		status.Branch = line[3:bytes.Index(line, []byte("..."))]
+	}
+
+	// detect limits for the ahead/behind status.
+	opening := bytes.Index(line, []byte{'['}) + 1
+	closing := bytes.Index(line, []byte{']'}) + 0
+
+	if opening == -1 || closing == -1 {
+		return
+	}
+
+	line = line[opening:closing]
+	line = bytes.ReplaceAll(line, []byte(u0020), []byte{})
+	bols = bytes.Split(line, []byte{','})
+
+	for _, part := range bols {
+		if len(part) < 6 {
+			continue
+		}
+
+		if bytes.Equal(part[0:5], []byte("ahead")) {
+			clean = bytes.Replace(part, []byte("ahead"), []byte{}, 1)
+			if number, err := strconv.Atoi(string(clean)); err == nil {
+				status.Ahead = number
+			}
+		}
+
+		if bytes.Equal(part[0:5], []byte("behin")) {
+			clean = bytes.Replace(part, []byte("behind"), []byte{}, 1)
+			if number, err := strconv.Atoi(string(clean)); err == nil {
+				status.Behind = number
+			}
+		}
+	}
+}
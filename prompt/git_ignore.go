@@ -0,0 +1,331 @@
+package prompt
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitIgnorePattern is one line in a .gitignore file. The base is the folder of
+// the .gitignore file, relative to the root of the worktree, and patterns are
+// matched against the paths relative to the base.
+type gitIgnorePattern struct {
+	Base     string
+	Pattern  string
+	Negate   bool
+	DirOnly  bool
+	Anchored bool
+}
+
+// parseGitIgnore parses the content of a .gitignore file.
+//
+//	> # comment
+//	> *.log
+//	> !important.log
+//	> /build/
+//	> docs/**/*.pdf
+func parseGitIgnore(data string, base string) []gitIgnorePattern {
+	var patterns []gitIgnorePattern
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p := gitIgnorePattern{Base: base}
+		if line[0] == '!' {
+			p.Negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.DirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// A pattern with a slash at the beginning or in the middle is matched
+		// relative to the base; otherwise it matches at any level.
+		p.Anchored = strings.Contains(line, "/")
+		p.Pattern = strings.TrimPrefix(line, "/")
+		if p.Pattern != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// match returns true if the pattern matches the path relative to the root of
+// the worktree.
+func (p gitIgnorePattern) match(name string, isDir bool) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+	if p.Base != "" {
+		var ok bool
+		if name, ok = strings.CutPrefix(name, p.Base+"/"); !ok {
+			return false
+		}
+	}
+	if !p.Anchored {
+		name = path.Base(name)
+	}
+	return wildmatch(p.Pattern, name)
+}
+
+// wildmatch matches a path against a pattern with the same rules as Git. A
+// single asterisk does not match a slash, and two consecutive asterisks match
+// zero or more folders if they are the only thing in a path component.
+func wildmatch(pattern string, name string) bool {
+	return wildmatchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// wildmatchParts matches the path components one by one.
+func wildmatchParts(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				// A trailing "/**" matches everything inside.
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if wildmatchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		p := strings.ReplaceAll(pattern[0], "[!", "[^")
+		if ok, err := path.Match(p, name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// gitIgnored returns true if the last pattern that matches the path is not a
+// negated pattern.
+func gitIgnored(patterns []gitIgnorePattern, name string, isDir bool) bool {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if patterns[i].match(name, isDir) {
+			return !patterns[i].Negate
+		}
+	}
+	return false
+}
+
+// globalGitIgnore returns the patterns in the .git/info/exclude file and in
+// the file defined by the core.excludesFile option, in order of precedence
+// from lowest to highest.
+func (r *gitRepository) globalGitIgnore() []gitIgnorePattern {
+	var patterns []gitIgnorePattern
+	home := os.Getenv("HOME")
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	excludes := r.config.Get("core.excludesfile")
+	for _, filename := range []string{filepath.Join(home, ".gitconfig"), filepath.Join(xdg, "git", "config")} {
+		if excludes != "" {
+			break
+		}
+		if config, err := readGitConfig(filename); err == nil {
+			excludes = config.Get("core.excludesfile")
+		}
+	}
+	if excludes == "" {
+		excludes = filepath.Join(xdg, "git", "ignore")
+	} else if rest, ok := strings.CutPrefix(excludes, "~/"); ok {
+		excludes = filepath.Join(home, rest)
+	}
+	for _, filename := range []string{excludes, filepath.Join(r.commondir, "info", "exclude")} {
+		if data, err := os.ReadFile(filename); err == nil {
+			patterns = append(patterns, parseGitIgnore(string(data), "")...)
+		}
+	}
+	return patterns
+}
+
+// untracked returns the files in the worktree that are neither in the index
+// nor ignored, same as `git status --untracked-files=normal`, which reports a
+// folder instead of its files if none of the files in the folder is tracked.
+func (r *gitRepository) untracked(index *gitIndex) ([]string, error) {
+	if value := r.config.Get("status.showuntrackedfiles"); value == "no" || value == "false" {
+		return nil, nil
+	}
+	tracked := map[string]bool{}
+	folders := map[string]bool{}
+	for _, entry := range index.Entries {
+		tracked[entry.Path] = true
+		for dir := path.Dir(entry.Path); dir != "." && !folders[dir]; dir = path.Dir(dir) {
+			folders[dir] = true
+		}
+	}
+	w := gitWalker{root: r.worktree, tracked: tracked, folders: folders}
+	err := w.walk("", r.globalGitIgnore(), false)
+	return w.found, err
+}
+
+// gitWalker finds the untracked files in the worktree.
+type gitWalker struct {
+	root    string
+	tracked map[string]bool
+	folders map[string]bool
+	found   []string
+}
+
+// walk reads a folder, which contains at least one tracked file, and records
+// its untracked files and untracked subfolders. The folder may be ignored if
+// its tracked files were added with `git add --force`, in which case only the
+// tracked subfolders are read.
+func (w *gitWalker) walk(dir string, patterns []gitIgnorePattern, ignored bool) error {
+	full := filepath.Join(w.root, filepath.FromSlash(dir))
+	patterns = w.loadGitIgnore(full, dir, patterns)
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if entry.Name() == ".git" || w.tracked[name] {
+			continue
+		}
+		isDir := entry.IsDir()
+		skip := ignored || gitIgnored(patterns, name, isDir)
+		if isDir && w.folders[name] {
+			if err := w.walk(name, patterns, skip); err != nil {
+				return err
+			}
+			continue
+		}
+		if skip {
+			continue
+		}
+		if isDir {
+			if ok, err := w.hasFiles(name, patterns); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			name += "/"
+		}
+		w.found = append(w.found, name)
+	}
+	return nil
+}
+
+// hasFiles returns true if an untracked folder contains at least one file that
+// is not ignored, or if the folder is a nested repository.
+func (w *gitWalker) hasFiles(dir string, patterns []gitIgnorePattern) (bool, error) {
+	full := filepath.Join(w.root, filepath.FromSlash(dir))
+	patterns = w.loadGitIgnore(full, dir, patterns)
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			return true, nil
+		}
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if gitIgnored(patterns, name, isDir) {
+			continue
+		}
+		if !isDir {
+			return true, nil
+		}
+		if ok, err := w.hasFiles(name, patterns); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// loadGitIgnore appends the patterns in the .gitignore file of the folder.
+func (w *gitWalker) loadGitIgnore(full string, dir string, patterns []gitIgnorePattern) []gitIgnorePattern {
+	data, err := os.ReadFile(filepath.Join(full, ".gitignore"))
+	if err != nil {
+		return patterns
+	}
+	// Copy the list to avoid modifying the patterns of the parent folder.
+	return append(patterns[:len(patterns):len(patterns)], parseGitIgnore(string(data), dir)...)
+}
+
+// gitIgnoreChecker tells whether the folders of a worktree are ignored, so the
+// daemon does not watch folders like node_modules or target.
+type gitIgnoreChecker struct {
+	mu       sync.Mutex
+	walker   gitWalker
+	global   []gitIgnorePattern
+	patterns map[string][]gitIgnorePattern
+}
+
+// newGitIgnoreChecker returns a checker for the worktree, or nil if the folder
+// is not a Git worktree.
+func newGitIgnoreChecker(root string) *gitIgnoreChecker {
+	repo, err := openGitRepository(root)
+	if err != nil {
+		return nil
+	}
+	folders := map[string]bool{}
+	if index, err := readGitIndex(filepath.Join(repo.gitdir, "index")); err == nil {
+		for _, entry := range index.Entries {
+			for dir := path.Dir(entry.Path); dir != "." && !folders[dir]; dir = path.Dir(dir) {
+				folders[dir] = true
+			}
+		}
+	}
+	return &gitIgnoreChecker{
+		walker:   gitWalker{root: repo.worktree, folders: folders},
+		global:   repo.globalGitIgnore(),
+		patterns: map[string][]gitIgnorePattern{},
+	}
+}
+
+// ignoredDir returns true if the folder, relative to the worktree, or one of
+// its parents is ignored, unless the folder contains tracked files.
+func (c *gitIgnoreChecker) ignoredDir(dir string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.walker.folders[dir] && c.matched(dir)
+}
+
+// matched returns true if the folder or one of its parents is ignored.
+func (c *gitIgnoreChecker) matched(dir string) bool {
+	if dir == "" || dir == "." {
+		return false
+	}
+	parent := path.Dir(dir)
+	if parent == "." {
+		parent = ""
+	}
+	return gitIgnored(c.patternsIn(parent), dir, true) || c.matched(parent)
+}
+
+// patternsIn returns the patterns that apply to the entries of the folder.
+func (c *gitIgnoreChecker) patternsIn(dir string) []gitIgnorePattern {
+	if patterns, ok := c.patterns[dir]; ok {
+		return patterns
+	}
+	patterns := c.global
+	if dir != "" {
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		patterns = c.patternsIn(parent)
+	}
+	patterns = c.walker.loadGitIgnore(filepath.Join(c.walker.root, filepath.FromSlash(dir)), dir, patterns)
+	c.patterns[dir] = patterns
+	return patterns
+}
@@ -0,0 +1,480 @@
+package prompt
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flags in the index entries.
+const (
+	gitIndexAssumeValid  = 0x8000
+	gitIndexExtended     = 0x4000
+	gitIndexStageMask    = 0x3000
+	gitIndexSkipWorktree = 0x4000 // extended flag.
+	gitIndexIntentToAdd  = 0x2000 // extended flag.
+)
+
+// Object types in the mode of the index and tree entries.
+const (
+	gitModeTypeMask = 0170000
+	gitModeTree     = 0040000
+	gitModeFile     = 0100000
+	gitModeSymlink  = 0120000
+	gitModeGitlink  = 0160000
+)
+
+// gitIndex holds the entries of the index file, also known as the staging
+// area, and the trees cached by the "TREE" extension, if present.
+type gitIndex struct {
+	Entries   []gitIndexEntry
+	CacheTree map[string]gitHash
+	Modified  time.Time
+}
+
+// gitIndexEntry is one file in the index. The stat data is a copy of the stat
+// information of the file in the worktree the last time the index was updated
+// and is used to detect changes without reading the content of the file.
+type gitIndexEntry struct {
+	Path      string
+	OID       gitHash
+	Mode      uint32
+	Size      uint32
+	MtimeSec  uint32
+	MtimeNsec uint32
+	Stage     int
+	Flags     uint16
+	ExtFlags  uint16
+}
+
+// readGitIndex parses an index file in version 2, 3 or 4. Other versions and
+// the "link" (split index) and "sdir" (sparse index) extensions are reported
+// as errGitUnsupported. A missing index file is the same as an empty index.
+//
+//	> 4-byte signature "DIRC"
+//	> 4-byte version number
+//	> 4-byte number of entries
+//	> entries, each with 40 bytes of stat data, the object name, 2 bytes of
+//	>   flags, 2 bytes of extended flags in version 3 and above, and the path
+//	> extensions, each with a 4-byte signature and a 4-byte size
+//	> 20-byte checksum
+func readGitIndex(filename string) (*gitIndex, error) {
+	index := &gitIndex{CacheTree: map[string]gitHash{}}
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index.Modified = info.ModTime()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	errInvalid := fmt.Errorf("invalid index %s", filename)
+	if len(data) < 12+sha1.Size || !bytes.Equal(data[:4], []byte("DIRC")) {
+		return nil, errInvalid
+	}
+	version := binary.BigEndian.Uint32(data[4:])
+	if version < 2 || version > 4 {
+		return nil, errGitUnsupported
+	}
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	data = data[12 : len(data)-sha1.Size]
+	var previous string
+	for range count {
+		if len(data) < 62 {
+			return nil, errInvalid
+		}
+		entry := gitIndexEntry{
+			MtimeSec:  binary.BigEndian.Uint32(data[8:]),
+			MtimeNsec: binary.BigEndian.Uint32(data[12:]),
+			Mode:      binary.BigEndian.Uint32(data[24:]),
+			Size:      binary.BigEndian.Uint32(data[36:]),
+			Flags:     binary.BigEndian.Uint16(data[60:]),
+		}
+		copy(entry.OID[:], data[40:])
+		entry.Stage = int(entry.Flags&gitIndexStageMask) >> 12
+		n := 62
+		if entry.Flags&gitIndexExtended != 0 {
+			if version < 3 || len(data) < 64 {
+				return nil, errInvalid
+			}
+			entry.ExtFlags = binary.BigEndian.Uint16(data[62:])
+			n = 64
+		}
+		rest := data[n:]
+		if version == 4 {
+			// The path is compressed: a variable-length integer with the
+			// number of bytes to remove from the end of the previous path,
+			// followed by the string to append to it.
+			strip, size := gitIndexVarint(rest)
+			if size == 0 || strip > len(previous) {
+				return nil, errInvalid
+			}
+			rest = rest[size:]
+			nul := bytes.IndexByte(rest, 0)
+			if nul == -1 {
+				return nil, errInvalid
+			}
+			entry.Path = previous[:len(previous)-strip] + string(rest[:nul])
+			data = rest[nul+1:]
+		} else {
+			nul := bytes.IndexByte(rest, 0)
+			if nul == -1 {
+				return nil, errInvalid
+			}
+			entry.Path = string(rest[:nul])
+			// Entries are padded with one to eight NUL bytes to keep the
+			// size of the entry a multiple of eight bytes.
+			size := (n + nul + 8) &^ 7
+			if len(data) < size {
+				return nil, errInvalid
+			}
+			data = data[size:]
+		}
+		previous = entry.Path
+		index.Entries = append(index.Entries, entry)
+	}
+	for len(data) >= 8 {
+		signature := string(data[:4])
+		size := int(binary.BigEndian.Uint32(data[4:]))
+		if len(data) < 8+size {
+			return nil, errInvalid
+		}
+		switch signature {
+		case "link", "sdir":
+			return nil, errGitUnsupported
+		case "TREE":
+			if _, err := parseGitCacheTree(data[8:8+size], "", index.CacheTree); err != nil {
+				return nil, err
+			}
+		}
+		data = data[8+size:]
+	}
+	return index, nil
+}
+
+// gitIndexVarint decodes the variable-length integers of the index version 4
+// and returns the value and the number of bytes read.
+func gitIndexVarint(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	c := data[0]
+	value := int(c & 0x7f)
+	i := 1
+	for c&0x80 != 0 {
+		if i >= len(data) {
+			return 0, 0
+		}
+		c = data[i]
+		i++
+		value = ((value + 1) << 7) | int(c&0x7f)
+	}
+	return value, i
+}
+
+// parseGitCacheTree parses the "TREE" extension of the index, which contains
+// the object names of the trees that match the entries in the index. Trees
+// invalidated by changes in the index have a negative number of entries.
+//
+//	> path component NUL
+//	> number of entries (ASCII) SPACE number of subtrees (ASCII) NEWLINE
+//	> 20-byte object name, if the tree is valid
+//	> subtrees, recursively
+func parseGitCacheTree(data []byte, prefix string, trees map[string]gitHash) ([]byte, error) {
+	errInvalid := fmt.Errorf("invalid cache tree")
+	nul := bytes.IndexByte(data, 0)
+	newline := bytes.IndexByte(data, '\n')
+	if nul == -1 || newline < nul {
+		return nil, errInvalid
+	}
+	name := string(data[:nul])
+	counts := strings.Fields(string(data[nul+1 : newline]))
+	if len(counts) != 2 {
+		return nil, errInvalid
+	}
+	entries, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return nil, errInvalid
+	}
+	subtrees, err := strconv.Atoi(counts[1])
+	if err != nil {
+		return nil, errInvalid
+	}
+	data = data[newline+1:]
+	if prefix != "" || name != "" {
+		prefix = path.Join(prefix, name)
+	}
+	if entries >= 0 {
+		if len(data) < sha1.Size {
+			return nil, errInvalid
+		}
+		var oid gitHash
+		copy(oid[:], data)
+		trees[prefix] = oid
+		data = data[sha1.Size:]
+	}
+	for range subtrees {
+		if data, err = parseGitCacheTree(data, prefix, trees); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// gitChange represents the status of one file in the short format of `git
+// status`, where X is the status of the index compared to HEAD and Y is the
+// status of the worktree compared to the index.
+type gitChange struct {
+	Path string
+	X    byte
+	Y    byte
+}
+
+// gitConflicts maps the stages of a file with conflicts to its status.
+//
+//	> DD - unmerged, both deleted (common ancestor only)
+//	> AU - unmerged, added by us
+//	> UD - unmerged, deleted by them
+//	> UA - unmerged, added by them
+//	> DU - unmerged, deleted by us
+//	> AA - unmerged, both added
+//	> UU - unmerged, both modified
+var gitConflicts = map[int][2]byte{
+	0b001: {'D', 'D'},
+	0b010: {'A', 'U'},
+	0b011: {'U', 'D'},
+	0b100: {'U', 'A'},
+	0b101: {'D', 'U'},
+	0b110: {'A', 'A'},
+	0b111: {'U', 'U'},
+}
+
+// diff compares HEAD, the index and the worktree, and returns the changes in
+// the same order as `git status`: files in the index first, then deleted and
+// unmerged files, then untracked files.
+func (r *gitRepository) diff(head gitHash, index *gitIndex) ([]gitChange, error) {
+	files := map[string]gitTreeEntry{}
+	clean := map[string]bool{}
+	if !head.IsZero() {
+		commit, err := r.objects.readCommit(head)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.flattenTree(commit.Tree, "", index.CacheTree, files, clean); err != nil {
+			return nil, err
+		}
+	}
+	var changes []gitChange
+	conflicts := map[string]int{}
+	staged := map[string]bool{}
+	added := map[gitHash]int{}
+	for _, entry := range index.Entries {
+		if entry.Stage != 0 {
+			conflicts[entry.Path] |= 1 << (entry.Stage - 1)
+			continue
+		}
+		staged[entry.Path] = true
+		change := gitChange{Path: entry.Path, X: ' ', Y: ' '}
+		if !isCleanPath(entry.Path, clean) {
+			if file, ok := files[entry.Path]; !ok {
+				change.X = 'A'
+			} else if file.OID != entry.OID || file.Mode != entry.Mode {
+				change.X = 'M'
+			}
+		}
+		if entry.ExtFlags&gitIndexIntentToAdd != 0 {
+			change.X, change.Y = ' ', 'A'
+		} else {
+			y, err := r.worktreeStatus(entry, index.Modified)
+			if err != nil {
+				return nil, err
+			}
+			change.Y = y
+		}
+		if change.X == 'A' {
+			added[entry.OID] = len(changes)
+		}
+		if change.X != ' ' || change.Y != ' ' {
+			changes = append(changes, change)
+		}
+	}
+	var paths []string
+	for name := range files {
+		if !staged[name] {
+			paths = append(paths, name)
+		}
+	}
+	for name := range conflicts {
+		if _, ok := files[name]; !ok {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+	for _, name := range paths {
+		if mask, ok := conflicts[name]; ok {
+			code := gitConflicts[mask]
+			changes = append(changes, gitChange{Path: name, X: code[0], Y: code[1]})
+			continue
+		}
+		if i, ok := added[files[name].OID]; ok {
+			// Only exact renames are detected, i.e. files that were moved to
+			// another location without changing their content.
+			delete(added, files[name].OID)
+			changes[i].X = 'R'
+			continue
+		}
+		changes = append(changes, gitChange{Path: name, X: 'D', Y: ' '})
+	}
+	untracked, err := r.untracked(index)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range untracked {
+		changes = append(changes, gitChange{Path: name, X: '?', Y: '?'})
+	}
+	return changes, nil
+}
+
+// isCleanPath returns true if the file is inside a folder that is the same in
+// HEAD and in the index according to the cache tree.
+func isCleanPath(name string, clean map[string]bool) bool {
+	if clean[""] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if clean[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenTree reads a tree and its subtrees into a list of files. Subtrees that
+// match the cache tree of the index are skipped and added to the clean list
+// because all the files inside are the same in the index.
+func (r *gitRepository) flattenTree(oid gitHash, prefix string, cache map[string]gitHash, files map[string]gitTreeEntry, clean map[string]bool) error {
+	if cached, ok := cache[prefix]; ok && cached == oid {
+		clean[prefix] = true
+		return nil
+	}
+	entries, err := r.objects.readTree(oid)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name
+		if prefix != "" {
+			name = prefix + "/" + entry.Name
+		}
+		if entry.Mode&gitModeTypeMask == gitModeTree {
+			if err := r.flattenTree(entry.OID, name, cache, files, clean); err != nil {
+				return err
+			}
+			continue
+		}
+		files[name] = entry
+	}
+	return nil
+}
+
+// worktreeStatus compares a file in the index with the file in the worktree
+// and returns ' ' if the file is unchanged, 'M' if the file was modified, 'T'
+// if the type of the file changed, or 'D' if the file was deleted. The content
+// of the file is only read if the stat data in the index does not match.
+func (r *gitRepository) worktreeStatus(entry gitIndexEntry, indexModified time.Time) (byte, error) {
+	if entry.Flags&gitIndexAssumeValid != 0 || entry.ExtFlags&gitIndexSkipWorktree != 0 {
+		return ' ', nil
+	}
+	if entry.Mode&gitModeTypeMask == gitModeGitlink {
+		// Same as `git status --ignore-submodules`.
+		return ' ', nil
+	}
+	filename := filepath.Join(r.worktree, filepath.FromSlash(entry.Path))
+	info, err := os.Lstat(filename)
+	if err != nil {
+		// Also ENOTDIR if a parent folder was replaced with a file.
+		return 'D', nil
+	}
+	if info.IsDir() {
+		return 'D', nil
+	}
+	isSymlink := info.Mode()&os.ModeSymlink != 0
+	if isSymlink != (entry.Mode&gitModeTypeMask == gitModeSymlink) {
+		return 'T', nil
+	}
+	if !isSymlink && r.config.Get("core.filemode") != "false" {
+		if (info.Mode()&0o111 != 0) != (entry.Mode&0o111 != 0) {
+			return 'M', nil
+		}
+	}
+	mtime := info.ModTime()
+	// A file modified in the same second the index was written may not have a
+	// different modification time, so the content must be compared instead;
+	// this is known as the "racy git" problem.
+	racy := !mtime.Before(indexModified)
+	if !racy &&
+		uint32(mtime.Unix()) == entry.MtimeSec &&
+		uint32(mtime.Nanosecond()) == entry.MtimeNsec &&
+		uint32(info.Size()) == entry.Size {
+		return ' ', nil
+	}
+	var data []byte
+	if isSymlink {
+		target, err := os.Readlink(filename)
+		if err != nil {
+			return 0, err
+		}
+		data = []byte(target)
+	} else if data, err = os.ReadFile(filename); err != nil {
+		return 0, err
+	}
+	if hashGitBlob(data) == entry.OID {
+		return ' ', nil
+	}
+	if r.hasContentFilters(entry.Path) {
+		// The content in the index may be the result of a clean filter, e.g.
+		// Git LFS, or line ending conversions, which are not supported.
+		return 0, errGitUnsupported
+	}
+	return 'M', nil
+}
+
+// hasContentFilters returns true if the file may be converted before it is
+// stored in the repository, either because of the core.autocrlf option or
+// because of attributes like "filter", "text" or "eol" in .gitattributes.
+func (r *gitRepository) hasContentFilters(name string) bool {
+	if value := r.config.Get("core.autocrlf"); value == "true" || value == "input" {
+		return true
+	}
+	files := []string{filepath.Join(r.commondir, "info", "attributes")}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		files = append(files, filepath.Join(r.worktree, filepath.FromSlash(dir), ".gitattributes"))
+		if dir == "." {
+			break
+		}
+	}
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		for _, attr := range []string{"filter", "text", "eol", "crlf", "ident", "encoding"} {
+			if bytes.Contains(data, []byte(attr)) {
+				return true
+			}
+		}
+	}
+	return false
+}
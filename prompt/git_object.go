@@ -0,0 +1,460 @@
+package prompt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gitHash is the SHA-1 name of a Git object.
+type gitHash [sha1.Size]byte
+
+// parseGitHash converts a hexadecimal object name into a gitHash.
+func parseGitHash(s string) (gitHash, error) {
+	var oid gitHash
+	if len(s) != hex.EncodedLen(sha1.Size) {
+		return oid, fmt.Errorf("invalid object name %q", s)
+	}
+	_, err := hex.Decode(oid[:], []byte(s))
+	return oid, err
+}
+
+// IsZero returns true if the object name is empty.
+func (h gitHash) IsZero() bool {
+	return h == gitHash{}
+}
+
+// String returns the hexadecimal representation of the object name.
+func (h gitHash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// hashGitBlob returns the object name of a file with the given content.
+func hashGitBlob(data []byte) gitHash {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	var oid gitHash
+	copy(oid[:], h.Sum(nil))
+	return oid
+}
+
+// Object types as stored in the header of the entries of a packfile.
+const (
+	gitObjectCommit   = 1
+	gitObjectTree     = 2
+	gitObjectBlob     = 3
+	gitObjectTag      = 4
+	gitObjectOfsDelta = 6
+	gitObjectRefDelta = 7
+)
+
+// gitObjectTypes maps the names used in loose objects to the packfile types.
+var gitObjectTypes = map[string]int{
+	"commit": gitObjectCommit,
+	"tree":   gitObjectTree,
+	"blob":   gitObjectBlob,
+	"tag":    gitObjectTag,
+}
+
+// gitObjectStore reads objects from the objects folder of a repository, which
+// contains loose objects compressed with zlib and packfiles with their indexes.
+// Alternate object stores listed in objects/info/alternates are also read.
+type gitObjectStore struct {
+	dirs  []string
+	once  sync.Once
+	packs []*gitPack
+	err   error
+}
+
+// newGitObjectStore returns an object store for the objects folder.
+func newGitObjectStore(dir string) *gitObjectStore {
+	dirs := []string{dir}
+	if data, err := os.ReadFile(filepath.Join(dir, "info", "alternates")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			if !filepath.IsAbs(line) {
+				line = filepath.Join(dir, line)
+			}
+			dirs = append(dirs, line)
+		}
+	}
+	return &gitObjectStore{dirs: dirs}
+}
+
+// loadPacks reads the index of every packfile in the object store.
+func (s *gitObjectStore) loadPacks() error {
+	s.once.Do(func() {
+		for _, dir := range s.dirs {
+			names, err := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+			if err != nil {
+				s.err = err
+				return
+			}
+			for _, name := range names {
+				pack, err := openGitPack(name)
+				if err != nil {
+					s.err = err
+					return
+				}
+				s.packs = append(s.packs, pack)
+			}
+		}
+	})
+	return s.err
+}
+
+// readObject returns the type and the content of an object.
+func (s *gitObjectStore) readObject(oid gitHash) (int, []byte, error) {
+	name := oid.String()
+	for _, dir := range s.dirs {
+		typ, data, err := readGitLooseObject(filepath.Join(dir, name[:2], name[2:]))
+		if err == nil {
+			return typ, data, nil
+		}
+		if !os.IsNotExist(err) {
+			return 0, nil, err
+		}
+	}
+	if err := s.loadPacks(); err != nil {
+		return 0, nil, err
+	}
+	for _, pack := range s.packs {
+		if offset, ok := pack.find(oid); ok {
+			return pack.readAt(s, offset)
+		}
+	}
+	return 0, nil, fmt.Errorf("object %s not found", name)
+}
+
+// readGitLooseObject reads an object compressed with zlib with a header like
+// "blob 1234\x00" followed by the content of the object.
+func readGitLooseObject(filename string) (int, []byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+	zr, err := zlib.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return 0, nil, err
+	}
+	header, content, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid object %s", filename)
+	}
+	name, _, _ := bytes.Cut(header, []byte{' '})
+	typ, ok := gitObjectTypes[string(name)]
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid object type %q", name)
+	}
+	return typ, content, nil
+}
+
+// gitPack is a packfile and the content of its index (version 2).
+//
+//	> 4-byte magic number \377tOc
+//	> 4-byte version number (= 2)
+//	> 256 4-byte entries with the number of objects with a name starting with
+//	>   a byte less than or equal to the index of the entry (fan-out table)
+//	> N 20-byte object names, sorted
+//	> N 4-byte CRC32 values
+//	> N 4-byte offsets; if the most significant bit is set, the rest of the
+//	>   bits are the index of an entry in the next table
+//	> M 8-byte offsets
+type gitPack struct {
+	filename string
+	count    int
+	names    []byte
+	offsets  []byte
+	large    []byte
+}
+
+// openGitPack reads the index of a packfile.
+func openGitPack(filename string) (*gitPack, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, errGitUnsupported
+	}
+	count := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	start := 8 + 256*4
+	end := start + count*sha1.Size + count*4 + count*4
+	if len(data) < end {
+		return nil, fmt.Errorf("invalid pack index %s", filename)
+	}
+	return &gitPack{
+		filename: strings.TrimSuffix(filename, ".idx") + ".pack",
+		count:    count,
+		names:    data[start : start+count*sha1.Size],
+		offsets:  data[start+count*(sha1.Size+4) : end],
+		large:    data[end:],
+	}, nil
+}
+
+// find returns the offset of the object in the packfile.
+func (p *gitPack) find(oid gitHash) (int64, bool) {
+	i := sort.Search(p.count, func(i int) bool {
+		return bytes.Compare(p.names[i*sha1.Size:(i+1)*sha1.Size], oid[:]) >= 0
+	})
+	if i == p.count || !bytes.Equal(p.names[i*sha1.Size:(i+1)*sha1.Size], oid[:]) {
+		return 0, false
+	}
+	offset := binary.BigEndian.Uint32(p.offsets[i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+	i = int(offset & 0x7fffffff)
+	if len(p.large) < (i+1)*8 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(p.large[i*8:])), true
+}
+
+// readAt returns the type and the content of the object at the offset. Deltas
+// are resolved recursively, either from the same packfile or, for deltas that
+// refer to their base by name, from anywhere in the object store.
+func (p *gitPack) readAt(store *gitObjectStore, offset int64) (int, []byte, error) {
+	file, err := os.Open(p.filename)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+	return p.readEntry(store, file, offset, 0)
+}
+
+// readEntry reads one entry in the packfile.
+func (p *gitPack) readEntry(store *gitObjectStore, file *os.File, offset int64, depth int) (int, []byte, error) {
+	if depth > 64 {
+		return 0, nil, fmt.Errorf("delta chain too long in %s", p.filename)
+	}
+	r := bufio.NewReader(io.NewSectionReader(file, offset, 1<<62))
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	// The header is a variable-length integer with the type in bits 4-6 of
+	// the first byte and the size of the inflated content in the rest.
+	typ := int(b>>4) & 7
+	size := int64(b & 15)
+	for shift := 4; b&0x80 != 0; shift += 7 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= int64(b&0x7f) << shift
+	}
+	var baseType int
+	var base []byte
+	switch typ {
+	case gitObjectOfsDelta:
+		// The base is at a negative offset encoded as a big-endian number
+		// where each continuation adds one to avoid redundant encodings.
+		if b, err = r.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		distance := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = r.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			distance = ((distance + 1) << 7) | int64(b&0x7f)
+		}
+		baseType, base, err = p.readEntry(store, file, offset-distance, depth+1)
+	case gitObjectRefDelta:
+		var oid gitHash
+		if _, err = io.ReadFull(r, oid[:]); err != nil {
+			return 0, nil, err
+		}
+		baseType, base, err = store.readObject(oid)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return 0, nil, err
+	}
+	if base == nil {
+		return typ, data, nil
+	}
+	data, err = applyGitDelta(base, data)
+	return baseType, data, err
+}
+
+// applyGitDelta reconstructs an object from its base and a delta, which is the
+// size of the base and the size of the result followed by instructions to
+// either copy a range of bytes from the base or insert new bytes.
+func applyGitDelta(base []byte, delta []byte) ([]byte, error) {
+	errInvalid := errors.New("invalid delta")
+	varint := func() int {
+		var n, shift int
+		for len(delta) > 0 {
+			b := delta[0]
+			delta = delta[1:]
+			n |= int(b&0x7f) << shift
+			shift += 7
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		return n
+	}
+	if varint() != len(base) {
+		return nil, errInvalid
+	}
+	out := make([]byte, 0, varint())
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 == 0 {
+			// Insert the next op bytes from the delta.
+			n := int(op)
+			if n == 0 || n > len(delta) {
+				return nil, errInvalid
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+		// Copy a range from the base; the bits of the opcode indicate which
+		// bytes of the offset and the size are present in the delta.
+		var offset, size int
+		for i := 0; i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errInvalid
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				size |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > len(base) {
+			return nil, errInvalid
+		}
+		out = append(out, base[offset:offset+size]...)
+	}
+	if len(out) != cap(out) {
+		return nil, errInvalid
+	}
+	return out, nil
+}
+
+// gitCommit holds the fields of a commit object used by the program.
+type gitCommit struct {
+	OID     gitHash
+	Tree    gitHash
+	Parents []gitHash
+	Time    int64
+}
+
+// readCommit reads and parses a commit object.
+//
+//	> tree 9bedf67800b2923982bdf60c89c57ce6e2d4b4e9
+//	> parent 2f3f5e4e4cc4ee1c7b95b1e1d1dfb7bc1cc5d5d6
+//	> author John Doe <john@example.com> 1700000000 +0000
+//	> committer John Doe <john@example.com> 1700000000 +0000
+func (s *gitObjectStore) readCommit(oid gitHash) (gitCommit, error) {
+	commit := gitCommit{OID: oid}
+	typ, data, err := s.readObject(oid)
+	if err != nil {
+		return commit, err
+	}
+	if typ != gitObjectCommit {
+		return commit, fmt.Errorf("object %s is not a commit", oid)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			// The headers end at the first empty line.
+			break
+		}
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			commit.Tree, err = parseGitHash(value)
+		case "parent":
+			var parent gitHash
+			parent, err = parseGitHash(value)
+			commit.Parents = append(commit.Parents, parent)
+		case "committer":
+			fields := strings.Fields(value)
+			if len(fields) >= 2 {
+				commit.Time, _ = strconv.ParseInt(fields[len(fields)-2], 10, 64)
+			}
+		}
+		if err != nil {
+			return commit, err
+		}
+	}
+	return commit, nil
+}
+
+// gitTreeEntry is one file or folder in a tree object.
+type gitTreeEntry struct {
+	Mode uint32
+	Name string
+	OID  gitHash
+}
+
+// readTree reads and parses a tree object, which is a list of entries with
+// the mode in octal, a space, the name, a NUL byte and the binary object name.
+func (s *gitObjectStore) readTree(oid gitHash) ([]gitTreeEntry, error) {
+	typ, data, err := s.readObject(oid)
+	if err != nil {
+		return nil, err
+	}
+	if typ != gitObjectTree {
+		return nil, fmt.Errorf("object %s is not a tree", oid)
+	}
+	var entries []gitTreeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp == -1 || nul < sp || len(data) < nul+1+sha1.Size {
+			return nil, fmt.Errorf("invalid tree %s", oid)
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, err
+		}
+		entry := gitTreeEntry{Mode: uint32(mode), Name: string(data[sp+1 : nul])}
+		copy(entry.OID[:], data[nul+1:])
+		entries = append(entries, entry)
+		data = data[nul+1+sha1.Size:]
+	}
+	return entries, nil
+}
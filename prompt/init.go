@@ -0,0 +1,115 @@
+package prompt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// initScripts contains the hooks that integrate the program with each shell.
+// The hooks capture the exit status of the most recent command, the exit
+// status of every command in the most recent pipeline, the number of jobs in
+// the background and the time it took the most recent command to finish, and
+// then forward all this information to the program via flags.
+//
+// The %[1]s verb is replaced with the absolute path of the program followed by
+// the additional flags defined in the init command.
+var initScripts = map[string]string{
+	"bash": `__powergoline_prompt() {
+  local __powergoline_status="$?" __powergoline_pipe="${PIPESTATUS[*]}"
+  local __powergoline_jobs='\j'
+  local __powergoline_args=(
+    -shell=bash
+    -status.code="$__powergoline_status"
+    -status.pipe="$__powergoline_pipe"
+    -jobs.n="${__powergoline_jobs@P}"
+  )
+  if [ -n "$__powergoline_start" ] && [ -n "$EPOCHREALTIME" ]; then
+    local __powergoline_end="${EPOCHREALTIME/[.,]/}"
+    __powergoline_args+=(-duration.time="$(( (__powergoline_end - __powergoline_start) / 1000 ))ms")
+  fi
+  unset __powergoline_start
+  PS1="$(%[1]s "${__powergoline_args[@]}")"
+}
+if [ -n "$EPOCHREALTIME" ]; then
+  PS0='${__powergoline_start:0:$((__powergoline_start=${EPOCHREALTIME/[.,]/},0))}'"$PS0"
+fi
+case ";${PROMPT_COMMAND};" in
+  *";__powergoline_prompt;"*) ;;
+  *) PROMPT_COMMAND="__powergoline_prompt${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;;
+esac
+`,
+	"zsh": `zmodload zsh/datetime zsh/parameter
+autoload -Uz add-zsh-hook
+setopt prompt_subst
+__powergoline_preexec() {
+  __powergoline_start=$EPOCHREALTIME
+}
+__powergoline_precmd() {
+  local __powergoline_status=$? __powergoline_pipe="${pipestatus[*]}"
+  local -a __powergoline_args=(
+    -shell=zsh
+    -status.code=$__powergoline_status
+    -status.pipe="$__powergoline_pipe"
+    -jobs.n=${#jobstates}
+  )
+  if [[ -n $__powergoline_start ]]; then
+    local -i __powergoline_ms=$(( (EPOCHREALTIME - __powergoline_start) * 1000 ))
+    __powergoline_args+=(-duration.time=${__powergoline_ms}ms)
+  fi
+  unset __powergoline_start
+  PROMPT="$(%[1]s "${__powergoline_args[@]}")"
+}
+add-zsh-hook preexec __powergoline_preexec
+add-zsh-hook precmd __powergoline_precmd
+`,
+	"fish": `function fish_prompt
+  set -l __powergoline_pipe $pipestatus
+  set -l __powergoline_status $status
+  %[1]s \
+    -shell=fish \
+    -status.code=$__powergoline_status \
+    -status.pipe="$__powergoline_pipe" \
+    -jobs.n=(count (jobs -p)) \
+    -duration.time="$CMD_DURATION"ms
+end
+`,
+}
+
+// printInitScript prints the hook that integrates the program with the shell.
+// Additional arguments are forwarded to the program every time the hook runs,
+// for example: powergoline init bash -theme=wildcherry -repo.on
+func printInitScript(w io.Writer, shell string, args []string) error {
+	script, ok := initScripts[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q; use bash, zsh or fish", shell)
+	}
+	quote := quotePosix
+	if shell == "fish" {
+		quote = quoteFish
+	}
+	program, err := os.Executable()
+	if err != nil {
+		program = "powergoline"
+	}
+	words := []string{quote(program)}
+	for _, arg := range args {
+		words = append(words, quote(arg))
+	}
+	_, err = fmt.Fprintf(w, script, strings.Join(words, u0020))
+	return err
+}
+
+// quotePosix wraps the text in single quotes for Bash and Zsh.
+func quotePosix(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteFish wraps the text in single quotes for Fish, where backslashes and
+// single quotes are the only characters that can be escaped.
+func quoteFish(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "'", `\'`)
+	return "'" + s + "'"
+}
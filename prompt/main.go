@@ -0,0 +1,859 @@
+// Package prompt renders the command line prompt of powergoline. Custom
+// binaries add their own segments with Register and then call Main, see the
+// main package of the project for the default binary.
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// config is the user-provided configuration.
+var config Config
+
+const defaultPluginTimeout time.Duration = time.Second * 3
+
+// defaultSegments is the order in which the segments are rendered by default.
+const defaultSegments string = "time,user,host,cwd,repo,plugins,jobs,duration,status"
+
+var segments = map[string]SegmentFunc{
+	"time":     segmentDatetime,
+	"user":     segmentUsername,
+	"host":     segmentHostname,
+	"cwd":      segmentDirectories,
+	"repo":     segmentRepoStatus,
+	"plugins":  segmentCallPlugins,
+	"jobs":     segmentJobs,
+	"duration": segmentDuration,
+	"status":   segmentExitCode,
+}
+
+var themes = map[string]func(Config) Config{
+	"agnoster":   ApplyAgnosterTheme,
+	"astrocom":   ApplyAstrocomTheme,
+	"bluescale":  ApplyBlueScaleTheme,
+	"colorish":   ApplyColorishTheme,
+	"grayscale":  ApplyGrayScaleTheme,
+	"wildcherry": ApplyWildCherryTheme,
+}
+
+// Main parses the command line, renders the prompt and exits. It also runs the
+// `init` and `daemon` commands.
+func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		var shell string
+		if len(os.Args) > 2 {
+			shell = os.Args[2]
+		}
+		if err := printInitScript(os.Stdout, shell, os.Args[min(len(os.Args), 3):]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == pluginRefreshCommand {
+		if err := runPluginRefresh(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "daemon" {
+		if err := runDaemon(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	flag.BoolVar(&config.Debug, "debug", false, "Prints plugin runtime statistics")
+	flag.BoolVar(&config.TimeOn, "time.on", false, "Prints date and time, use -time.fmt to format")
+	ColorVar(&config.TimeFg, "time.fg", 255, "Defines the date and time foreground color")
+	ColorVar(&config.TimeBg, "time.bg", 13, "Defines the date and time background color")
+	flag.StringVar(&config.TimeFmt, "time.fmt", "2006-01-02 15:04:05", "Defines the date and time segment format")
+	flag.BoolVar(&config.UserOn, "user.on", false, "Prints the current username")
+	ColorVar(&config.UserFg, "user.fg", 255, "Defines the username foreground color")
+	ColorVar(&config.UserBg, "user.bg", 33, "Defines the username background color")
+	flag.BoolVar(&config.HostOn, "host.on", false, "Prints the current hostname")
+	ColorVar(&config.HostFg, "host.fg", 255, "Defines the hostname foreground color")
+	ColorVar(&config.HostBg, "host.bg", 75, "Defines the hostname background color")
+	ColorVar(&config.HomeFg, "home.fg", 255, "Defines the home directory foreground color")
+	ColorVar(&config.HomeBg, "home.bg", 105, "Defines the home directory background color")
+	ColorVar(&config.RodirFg, "rodir.fg", 255, "Defines the read-only directory foreground color")
+	ColorVar(&config.RodirBg, "rodir.bg", 124, "Defines the read-only directory background color")
+	flag.IntVar(&config.CwdN, "cwd.n", 1, "Defines how many folder levels to print")
+	flag.BoolVar(&config.CwdOn, "cwd.on", true, "Prints the current working directory")
+	ColorVar(&config.CwdFg, "cwd.fg", 255, "Defines the current working directory foreground color")
+	ColorVar(&config.CwdBg, "cwd.bg", 99, "Defines the current working directory background color")
+	flag.BoolVar(&config.RepoOn, "repo.on", false, "Prints the Git/Mercurial/Subversion status")
+	ColorVar(&config.RepoFg, "repo.fg", 0, "Defines the repository status foreground color")
+	ColorVar(&config.RepoBg, "repo.bg", 255, "Defines the repository status background color")
+	ColorVar(&config.RepoStaged, "repo.staged", -1, "Defines the foreground color of the staged files counter\nUse -1 to inherit the repo.fg color, same for the other counters.")
+	ColorVar(&config.RepoUnstaged, "repo.unstaged", -1, "Defines the foreground color of the unstaged files counter")
+	ColorVar(&config.RepoUntracked, "repo.untracked", -1, "Defines the foreground color of the untracked files counter")
+	ColorVar(&config.RepoConflicts, "repo.conflicts", -1, "Defines the foreground color of the merge conflicts counter")
+	ColorVar(&config.RepoRenamed, "repo.renamed", -1, "Defines the foreground color of the renamed files counter")
+	ColorVar(&config.RepoStashed, "repo.stashed", -1, "Defines the foreground color of the stash entries counter")
+	ColorVar(&config.RepoClean, "repo.clean", -1, "Defines the repository background color without changes\nUse -1 to inherit the repo.bg color, same for the other states.")
+	ColorVar(&config.RepoDirty, "repo.dirty", -1, "Defines the repository background color with uncommitted changes")
+	ColorVar(&config.RepoAhead, "repo.ahead", -1, "Defines the repository background color with unpushed commits")
+	ColorVar(&config.RepoBehind, "repo.behind", -1, "Defines the repository background color with unpulled commits")
+	ColorVar(&config.RepoDiverged, "repo.diverged", -1, "Defines the repository background color with unpushed and unpulled commits")
+	ColorVar(&config.RepoConflicted, "repo.conflicted", -1, "Defines the repository background color with merge conflicts")
+	flag.Var(&config.RepoExclude, "repo.exclude", "Sets repo.on=false for the specified folder and its subfolders\nUse multiple times or glob patterns like -repo.exclude=\"~/huge-monorepo/**\"")
+	flag.Var(&config.RepoInclude, "repo.include", "Sets repo.on=true for the specified folder and its subfolders\nThe exclusions take precedence over the inclusions.")
+	flag.DurationVar(&config.RepoCache, "repo.cache", 0, "Reuses the repository status for up to this long while the index, HEAD and refs are unchanged\nUseful in very large repositories, e.g. -repo.cache=1m; changes in the worktree are noticed after this time.")
+	flag.Var(&config.Plugins, "plugin", "Defines a plugin with optional arguments (e.g. -plugin=\"echo hello world\")\nDefine multiple plugins like this: -plugin=A -plugin=B -plugin=C\nDefine the options of a plugin with a JSON object (e.g. -plugin='{\"command\": \"date\", \"timeout\": \"1s\"}')")
+	ColorVar(&config.PluginFg, "plugin.fg", 0, "Defines the plugin output foreground color")
+	ColorVar(&config.PluginBg, "plugin.bg", 11, "Defines the plugin output background color")
+	flag.DurationVar(&config.PluginTimeout, "plugin.timeout", time.Second*5, "Maximum time to wait for a plugin execution")
+	flag.Var(pluginAsyncFlag{&config.Plugins}, "plugin.async", "Defines a plugin that prints the output of its previous execution, refreshed in the background")
+	flag.DurationVar(&config.PluginCache, "plugin.cache", 0, "Reuses the output of the plugins for this long, saved in $XDG_CACHE_HOME (0 to disable)")
+	flag.BoolVar(&config.PluginCachePwd, "plugin.cache.pwd", true, "Caches the output of the plugins per folder; disable for plugins that do not depend on $PWD")
+	flag.DurationVar(&config.PluginStale, "plugin.stale", time.Minute, "Marks the output of asynchronous plugins older than this as stale (0 to disable)")
+	flag.StringVar(&config.SymbolRoot, "symbol.root", "#", "Defines the prompt symbol for the Root user session")
+	flag.StringVar(&config.SymbolUser, "symbol.user", "$", "Defines the prompt symbol for a Regular user session")
+	flag.IntVar(&config.JobsN, "jobs.n", 0, "Number of jobs running in the background")
+	ColorVar(&config.JobsFg, "jobs.fg", 255, "Defines the background jobs foreground color")
+	ColorVar(&config.JobsBg, "jobs.bg", 94, "Defines the background jobs background color")
+	flag.DurationVar(&config.DurationTime, "duration.time", 0, "Execution time of the most recent program execution")
+	flag.DurationVar(&config.DurationMin, "duration.min", time.Second*2, "Minimum execution time to print the duration segment")
+	ColorVar(&config.DurationFg, "duration.fg", 255, "Defines the execution time foreground color")
+	ColorVar(&config.DurationBg, "duration.bg", 240, "Defines the execution time background color")
+	ColorVar(&config.StatusFg, "status.fg", 255, "Defines the program exit status foreground color")
+	flag.IntVar(&config.StatusCode, "status.code", -1, "Exit status code of the most recent program execution")
+	flag.StringVar(&config.StatusPipe, "status.pipe", "", "Exit status codes of the most recent pipeline (e.g. -status.pipe=\"0 1 0\")")
+	ColorVar(&config.StatusSuccess, "status.success", 41, "Defines the background color for exit(0)\nOperation success and generic status code.")
+	ColorVar(&config.StatusError, "status.error", 1, "Defines the background color for exit(1)\nCatchall for general errors and failures.")
+	ColorVar(&config.StatusMisuse, "status.misuse", 3, "Defines the background color for exit(2)\nMisuse of shell builtins, missing command or permission problem.")
+	ColorVar(&config.StatusCantExec, "status.cantexec", 4, "Defines the background color for exit(126)\nCannot execute command, permission problem, or not an executable.")
+	ColorVar(&config.StatusNotFound, "status.notfound", 14, "Defines the background color for exit(127)\nCommand not found, illegal path, or possible typo.")
+	ColorVar(&config.StatusInvalid, "status.invalid", 202, "Defines the background color for exit(128)\nInvalid argument to exit, only use range 0-255.")
+	ColorVar(&config.StatusErrSignal, "status.errsignal", 8, "Defines the background color for exit(128+n)\nFatal error signal where \"n\" is the PID.")
+	ColorVar(&config.StatusTerminated, "status.terminated", 13, "Defines the background color for exit(130)\nScript terminated by Control-C.")
+	ColorVar(&config.StatusOutofrange, "status.outofrange", 0, "Defines the background color for exit(255*)\nExit status out of range.")
+	flag.BoolVar(&config.DaemonOn, "daemon.on", true, "Asks the daemon for the repository status and the plugin outputs, if running\nStart the daemon with `powergoline daemon`; the segments are rendered directly otherwise.")
+	flag.StringVar(&config.Segments, "segments", defaultSegments, "Defines which segments to print and in what order, separated by commas\nSegments still honor their own flags, e.g. -time.on\nCustom binaries may define more segments, see prompt.Register")
+	flag.StringVar(&config.Colors, "colors", "auto", "Defines the number of colors supported by the terminal (auto, truecolor, 256, 16)\nRGB colors like #RRGGBB are converted to the nearest supported color.")
+	flag.BoolVar(&config.Plain, "plain", false, "Prints the prompt without colors and with ASCII symbols only\nSame as setting the NO_COLOR environment variable.")
+	flag.StringVar(&config.Shell, "shell", defaultShell, "Defines the shell that will interpret the prompt (bash, zsh, fish)")
+	flag.String("config", defaultConfigFile(), "Reads the options from a JSON file, flags override the values in the file")
+	flag.StringVar(&config.Theme, "theme", "", "Automatic color selection based on a color scheme.\nChoose among these predefined color schemes: \n* agnoster\n* astrocom\n* bluescale\n* colorish\n* grayscale\n* wildcherry\nOr use a path to a JSON file, or the name of a file in the themes directory.")
+
+	if err := parseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if config.Theme != "" {
+		applyThemeConfig, err := themeFor(config.Theme)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		config = applyThemeConfig(config)
+	}
+
+	arr, err := segmentsFor(config.Segments)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// The theme is already applied, NewPowergoline would read the file again.
+	(&Powergoline{config: config}).Render(os.Stdout, arr)
+}
+
+// segmentsFor returns the segment functions in the same order as the names in
+// the comma-separated list, e.g. "cwd,repo,plugins,status". The position of a
+// segment in the list defines its priority when the prompt is rendered.
+func segmentsFor(names string) ([]SegmentFunc, error) {
+	var arr []SegmentFunc
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		fn, ok := segments[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown segment %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate segment %q", name)
+		}
+		seen[name] = true
+		arr = append(arr, fn)
+	}
+	return arr, nil
+}
+
+const (
+	u000A string = "\u000A" // u000A is Unicode for `\n` (new line).
+	u0020 string = "\u0020" // u0020 is Unicode for `\s` (whitespace).
+	u00BB string = "\u00BB" // u00BB is Unicode for `»` (right-pointing double angle quotation mark).
+	u2026 string = "\u2026" // u2026 is Unicode for `…` (ellipsis).
+	u21E1 string = "\u21E1" // u21E1 is Unicode for `⇡` (upwards dashed arrow).
+	u21E3 string = "\u21E3" // u21E3 is Unicode for `⇣` (downwards dashed arrow).
+	u231B string = "\u231B" // u231B is Unicode for `⌛` (hourglass).
+	u2424 string = "\u2424" // u2424 is Unicode for `␤` (symbol for new line).
+	u25C9 string = "\u25C9" // u25C9 is Unicode for `◉` (fisheye).
+	u25CF string = "\u25CF" // u25CF is Unicode for `●` (black circle).
+	u25F7 string = "\u25F7" // u25F7 is Unicode for `◷` (white circle with upper right quadrant).
+	u2691 string = "\u2691" // u2691 is Unicode for `⚑` (black flag).
+	u2699 string = "\u2699" // u2699 is Unicode for `⚙` (gear).
+	u2716 string = "\u2716" // u2716 is Unicode for `✖` (heavy multiplication x).
+	u271A string = "\u271A" // u271A is Unicode for `✚` (heavy greek cross).
+	uE0A0 string = "\uE0A0" // uE0A0 is Unicode for `` (GitHub fork symbol).
+	uE0A2 string = "\uE0A2" // uE0A2 is Unicode for `` (GitHub lock symbol).
+	uE0B0 string = "\uE0B0" // uE0B0 is Unicode for `` (powerline arrow body).
+	uE0B1 string = "\uE0B1" // uE0B1 is Unicode for `` (powerline arrow line).
+)
+
+// asciiGlyphs replaces the Unicode symbols with ASCII equivalents for serial
+// consoles, screen readers and terminal emulators without patched fonts.
+var asciiGlyphs = strings.NewReplacer(
+	u00BB, ">>",
+	u2026, "...",
+	u21E1, "^",
+	u21E3, "v",
+	u231B, "took",
+	u2424, "|",
+	u25C9, "jj:",
+	u25CF, "*",
+	u25F7, "~",
+	u2691, "stash:",
+	u2699, "jobs",
+	u2716, "x",
+	u271A, "!",
+	uE0A0, "git:",
+	uE0A2, "[ro]",
+	uE0B0, ">",
+	uE0B1, ">",
+)
+
+type SegmentKind int
+
+const (
+	TextBox SegmentKind = iota
+	FolderBox
+	ArrowBox
+	LockBox
+	RepoStatusBox
+	PluginBox
+	ExitCodeBox
+)
+
+// errEmptyOutput defines an error when executing a command with no output.
+var errEmptyOutput = errors.New("empty output")
+
+// Powergoline holds the configuration either defined by the current user in
+// the TTY session or the default settings defined by the program on startup.
+// It also holds the bytes that will be printed in the command line prompt in
+// the form of segments.
+type Powergoline struct {
+	config Config
+}
+
+// Segment represents one single part in the command line prompt. Each segment
+// contains the text and color for the foreground and background of that text.
+// Notice that most segments have a spacing on the left and right side to keep
+// things in shape.
+type Segment struct {
+	Kind  SegmentKind // type of box that the segment represents.
+	Index int         // order in which to render.
+	Show  bool        // render if true, hide if false.
+	Fg    Color       // foreground color.
+	Bg    Color       // background color.
+	Text  string      // text to render.
+	Raw   bool        // text is already escaped for the shell.
+	Bold  bool        // render the text in bold.
+}
+
+// PluginOutput struct represents the output of an external program after its
+// execution along with some runtime information and an index. The index field
+// is used to keep track of the order in which the programs were executed; for
+// example, the first program to execute will have an index of 0, the second
+// will have an index of 1, and so on.
+//
+// This struct is typically used in conjunction with a slice of PluginOutput
+// structs, where each struct in the slice represents the output of a single
+// program execution.
+type PluginOutput struct {
+	Index   int
+	Output  string
+	Runtime time.Duration
+}
+
+// RepoStatus holds the information of the current state of a repository, this
+// includes the number of untracked files, number of commits ahead from remote,
+// number of commits behind compared to the state of the remote repository,
+// and nothing in case the state of the local repository is the same as the
+// remote version.
+//
+// Added, Deleted and Modified count the files by the kind of change, while
+// Staged and Unstaged count the files with changes in the index and in the
+// worktree respectively, so a file can be counted in both.
+type RepoStatus struct {
+	Branch    []byte
+	Ahead     int
+	Behind    int
+	Added     int
+	Deleted   int
+	Modified  int
+	Staged    int
+	Unstaged  int
+	Untracked int
+	Conflicts int
+	Renamed   int
+	Stashed   int
+	Operation string // e.g. "REBASE 3/7" or "MERGING".
+	Symbol    string // printed before the branch, defaults to uE0A0.
+}
+
+// NewPowergoline loads the config file and instantiates Powergoline.
+func NewPowergoline(config Config) *Powergoline {
+	if config.Theme != "" {
+		if applyThemeConfig, err := themeFor(config.Theme); err == nil {
+			config = applyThemeConfig(config)
+		}
+	}
+	return &Powergoline{config: config}
+}
+
+type SegmentFunc func(*sync.WaitGroup, chan struct{}, chan Segment, int, Config)
+
+func (p *Powergoline) Render(w io.Writer, arr []SegmentFunc) {
+	var wg sync.WaitGroup
+	out := make(chan Segment)
+	sem := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go consumer(w, done, out, p.config)
+	for priority, fn := range arr {
+		wg.Add(1)
+		sem <- struct{}{ /* lock */ }
+		// Multiply priority by one thousand to create a buffer in between
+		// segments in case the program needs to add additional (virtual)
+		// segments like arrows, indicators or plugin outputs after the
+		// explicit segment.
+		go fn(&wg, sem, out, priority*1000, p.config)
+	}
+	wg.Wait()
+	close(sem)
+	close(out)
+	<-done
+}
+
+func consumer(w io.Writer, done chan struct{}, out chan Segment, config Config) {
+	defer close(done)
+	shell := shellFor(config.Shell)
+	depth := colorDepthFor(config.Colors)
+	var segments []Segment
+	for box := range out {
+		if !box.Show || box.Text == "" {
+			// Skip unnecessary segments.
+			continue
+		}
+		if !box.Raw {
+			// Prevent arbitrary code execution in subshell expressions.
+			box.Text = shell.Escape(box.Text)
+		}
+		segments = append(segments, box)
+		// Add an arrow pointing to the next segment; set colors later.
+		//
+		// ┌───┬───┬─────┬───┬─────────────┬───┬────────┬───┬───┬───┬───┐
+		// │ ~ │ > │ ... │ > │ powergoline │ > │ foobar │ > │ $ │ > │   │
+		// └───┴───┴─────┴───┴─────────────┴───┴────────┴───┴───┴───┴───┘
+		//       ▲         ▲                 ▲            ▲       ▲   ▲
+		//       │         │                 │            │       │   │
+		//     arrow     arrow             arrow        arrow   arrow empty
+		arrow := Segment{Kind: ArrowBox, Index: box.Index + 1, Text: uE0B0}
+		segments = append(segments, arrow)
+	}
+	// Sort segments based on their original priority.
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Index < segments[j].Index
+	})
+	// Once sorted, check the list again and, if the segment is an arrow, then
+	// set the correct foreground and background colors. Foreground color must
+	// be the background color of the previous segment. Background color must
+	// be the background color of the next segment, if exists.
+	n := len(segments)
+	for i := 0; i < n; i++ {
+		if segments[i].Kind == ArrowBox {
+			segments[i].Fg = segments[i-1].Bg
+			segments[i].Bg = -1 /* default: no color */
+			// Replace type of arrow if between plugin outputs with the same
+			// background color.
+			if i-1 >= 0 /* check if a previous box exists */ &&
+				i+1 < n /* check if a next box exists */ &&
+				segments[i-1].Kind == PluginBox &&
+				segments[i+1].Kind == PluginBox &&
+				segments[i-1].Bg == segments[i+1].Bg {
+				segments[i].Fg = -1
+				segments[i].Text = uE0B1
+			}
+			// Set the background color, if there is a next box.
+			if i+1 < n {
+				segments[i].Bg = segments[i+1].Bg
+			}
+		}
+	}
+	// See https://no-color.org/ for more information.
+	plain := config.Plain || os.Getenv("NO_COLOR") != ""
+	for i, box := range segments {
+		if plain {
+			if box.Kind == ArrowBox && i == n-1 {
+				// Without colors, the last arrow points to nothing.
+				continue
+			}
+			box.Fg, box.Bg, box.Bold = -1, -1, false
+			box.Text = asciiGlyphs.Replace(box.Text)
+		}
+		if box.Show || box.Kind == ArrowBox {
+			printOneSegment(w, shell, depth, box)
+		}
+	}
+	_, _ = fmt.Fprint(w, u0020)
+}
+
+func printOneSegment(w io.Writer, shell Shell, depth ColorDepth, seg Segment) {
+	var color string
+	if seg.Bold {
+		color = "1;"
+	}
+	fore := seg.Fg.sgr(false, depth)
+	back := seg.Bg.sgr(true, depth)
+	// Add the foreground and background colors.
+	if seg.Fg > -1 && seg.Bg > -1 {
+		color += fore + ";" + back
+	} else if seg.Fg > -1 {
+		color += fore
+	} else if seg.Bg > -1 {
+		color += back
+	} else {
+		color = strings.TrimSuffix(color, ";")
+	}
+	// Draw the color sequences if necessary.
+	if len(color) > 0 {
+		_, _ = fmt.Fprint(w, shell.ColorStart+color+shell.ColorEnd+seg.Text+shell.ColorStart+"0"+shell.ColorEnd)
+	} else {
+		_, _ = fmt.Fprint(w, seg.Text)
+	}
+}
+
+// segmentDatetime prints the current date and time.
+func segmentDatetime(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if !config.TimeOn {
+		return
+	}
+	out <- Segment{Kind: TextBox, Index: priority, Show: true, Fg: config.TimeFg, Bg: config.TimeBg, Text: u0020 + time.Now().Format(config.TimeFmt) + u0020}
+}
+
+// segmentUsername prints the name of the current system user, e.g. root.
+func segmentUsername(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if !config.UserOn {
+		return
+	}
+	out <- Segment{Kind: TextBox, Index: priority, Show: true, Fg: config.UserFg, Bg: config.UserBg, Text: u0020 + shellFor(config.Shell).Username() + u0020, Raw: true}
+}
+
+// segmentHostname prints the name of this system.
+func segmentHostname(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if !config.HostOn {
+		return
+	}
+	out <- Segment{Kind: TextBox, Index: priority, Show: true, Fg: config.HostFg, Bg: config.HostBg, Text: u0020 + shellFor(config.Shell).Hostname() + u0020, Raw: true}
+}
+
+// SEP is same as os.PathSeparator but as a string.
+const SEP string = "/"
+
+// segmentDirectories prints the current location of the user in the system.
+func segmentDirectories(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if !config.CwdOn {
+		return
+	}
+
+	// Do not use os.UserHomeDir() and os.Getwd() as they resolve the path on
+	// disk and may expand symbolic links or return an absolute path different
+	// from what the user typed. We need the shell-exported HOME and PWD so the
+	// prompt matches the exact working directory shown by the shell (including
+	// symlinks and automount views) when composing PS1.
+	homedir := os.Getenv("HOME")
+	workdir := os.Getenv("PWD")
+
+	// start with the entire folder path, then reduce as we remove sections.
+	subfolders := workdir
+
+	// first character in the folder path, e.g. / (forward-slash) or ~ (tilde).
+	root := SEP
+
+	if workdir == SEP {
+		// User is at the root of the file system, so simply print a forward slash.
+		subfolders = ""
+	} else if workdir == homedir {
+		// Add a tilde to represent that we are inside the home directory.
+		root = "~"
+		subfolders = ""
+	} else if strings.HasPrefix(workdir, homedir) {
+		root = "~"
+		// Remove homedir from workdir and decorate the remaining folder path.
+		subfolders = workdir[len(homedir)+1:]
+	} else {
+		// User is somewhere else in the system outside the $HOME directory.
+		subfolders = subfolders[1:]
+	}
+
+	out <- Segment{Kind: FolderBox, Index: priority, Show: true, Fg: config.HomeFg, Bg: config.HomeBg, Text: u0020 + root + u0020}
+
+	if subfolders != "" {
+		// Plus one to account for the first characters in the entire folder
+		// path that was removed in the conditions leading up to the creation
+		// of the subfolders variable.
+		nSections := strings.Count(subfolders, SEP) + 1
+		if nSections > config.CwdN {
+			// Path too long; replace parent folders with an ellipsis.
+			sections := strings.Split(subfolders, SEP)
+			sections = sections[nSections-config.CwdN : nSections]
+			sections = append([]string{u2026}, sections...)
+			subfolders = strings.Join(sections, SEP)
+		}
+		// Replace all folder separators (forward-slash) with light arrows.
+		subfolders = strings.ReplaceAll(subfolders, SEP, u0020+uE0B1+u0020)
+		out <- Segment{Kind: LockBox, Index: priority + 2, Show: true, Fg: config.CwdFg, Bg: config.CwdBg, Text: u0020 + subfolders + u0020}
+	}
+
+	if unix.Access(workdir, unix.W_OK) != nil {
+		// Draw lock symbol if the current directory is read-only.
+		out <- Segment{Kind: FolderBox, Index: priority + 4, Show: true, Fg: config.RodirFg, Bg: config.RodirBg, Text: u0020 + uE0A2 + u0020}
+	}
+}
+
+// segmentRepoStatus prints the status of the current version control system.
+func segmentRepoStatus(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if !repoEnabled(config, os.Getenv("PWD"), os.Getenv("HOME")) {
+		// Disabled globally or per-repository.
+		return
+	}
+	var err error
+	var status RepoStatus
+	// check if a repository exists in the current folder or its parents.
+	root, kind := findRepository(os.Getenv("PWD"), os.Getenv("HOME"))
+	if backend := repoBackendFor(kind); backend != nil {
+		if res, derr := askDaemon(config, daemonRequest{Root: root, Kind: kind}); derr == nil {
+			status, err = res.Status, res.err()
+		} else {
+			status, err = repoStatusCached(backend, root, config.RepoCache)
+		}
+	}
+	if err != nil {
+		out <- Segment{Kind: RepoStatusBox, Index: priority, Show: true, Fg: config.RepoFg, Bg: config.RepoBg, Text: u0020 + err.Error() + u0020}
+		return
+	}
+	if len(status.Branch) == 0 {
+		// hide as there is no information to show.
+		return
+	}
+	text, raw := repoStatusText(status, config)
+	out <- Segment{Kind: RepoStatusBox, Index: priority, Show: true, Fg: config.RepoFg, Bg: repoStatusBg(status, config), Text: text, Raw: raw}
+}
+
+// repoStatusBg returns the background color for the state of the repository.
+// Conflicts take precedence over uncommitted changes, which take precedence
+// over the commits ahead or behind the upstream branch.
+func repoStatusBg(status RepoStatus, config Config) Color {
+	color := config.RepoClean
+	switch {
+	case status.Conflicts > 0:
+		color = config.RepoConflicted
+	case status.Added+status.Modified+status.Deleted+status.Staged+status.Unstaged+status.Untracked+status.Renamed > 0:
+		color = config.RepoDirty
+	case status.Ahead > 0 && status.Behind > 0:
+		color = config.RepoDiverged
+	case status.Ahead > 0:
+		color = config.RepoAhead
+	case status.Behind > 0:
+		color = config.RepoBehind
+	}
+	if color < 0 {
+		return config.RepoBg
+	}
+	return color
+}
+
+// repoStatusText returns the text of the repository segment. The counters with
+// their own foreground color are wrapped in color sequences, in which case the
+// text is escaped here and the function reports it as raw.
+func repoStatusText(status RepoStatus, config Config) (string, bool) {
+	counters := []struct {
+		Symbol string
+		Count  int
+		Fg     Color
+	}{
+		{Symbol: u21E1, Count: status.Ahead, Fg: -1},
+		{Symbol: u21E3, Count: status.Behind, Fg: -1},
+		{Symbol: "+", Count: status.Added, Fg: -1},
+		{Symbol: "~", Count: status.Modified, Fg: -1},
+		{Symbol: "-", Count: status.Deleted, Fg: -1},
+		{Symbol: u2716, Count: status.Conflicts, Fg: config.RepoConflicts},
+		{Symbol: u25CF, Count: status.Staged, Fg: config.RepoStaged},
+		{Symbol: u271A, Count: status.Unstaged, Fg: config.RepoUnstaged},
+		{Symbol: "?", Count: status.Untracked, Fg: config.RepoUntracked},
+		{Symbol: u00BB, Count: status.Renamed, Fg: config.RepoRenamed},
+		{Symbol: u2691, Count: status.Stashed, Fg: config.RepoStashed},
+	}
+	shell := shellFor(config.Shell)
+	depth := colorDepthFor(config.Colors)
+	plain := config.Plain || os.Getenv("NO_COLOR") != ""
+	// restore is the color sequence to go back to the color of the segment.
+	restore := shell.ColorStart + "39" + shell.ColorEnd
+	if config.RepoFg > -1 {
+		restore = shell.ColorStart + config.RepoFg.sgr(false, depth) + shell.ColorEnd
+	}
+	var buf, raw bytes.Buffer
+	var colored bool
+	symbol := status.Symbol
+	if symbol == "" {
+		symbol = uE0A0
+	}
+	fmt.Fprintf(&buf, " %s %s", symbol, status.Branch)
+	if status.Operation != "" {
+		fmt.Fprintf(&buf, " %s", status.Operation)
+	}
+	raw.WriteString(shell.Escape(buf.String()))
+	for _, counter := range counters {
+		if counter.Count == 0 {
+			continue
+		}
+		text := fmt.Sprintf(" %s%d", counter.Symbol, counter.Count)
+		buf.WriteString(text)
+		if counter.Fg > -1 && !plain {
+			colored = true
+			raw.WriteString(u0020 + shell.ColorStart + counter.Fg.sgr(false, depth) + shell.ColorEnd + text[1:] + restore)
+		} else {
+			raw.WriteString(text)
+		}
+	}
+	buf.WriteString(u0020)
+	raw.WriteString(u0020)
+	if colored {
+		return raw.String(), true
+	}
+	return buf.String(), false
+}
+
+func segmentCallPlugins(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if len(config.Plugins) == 0 {
+		return
+	}
+	env := pluginEnv(config)
+	for i, command := range config.Plugins {
+		wg.Add(1)
+		sem <- struct{}{ /* lock */ }
+		// Leave room for the segments of plugins with JSON output.
+		go segmentCallOnePlugin(wg, sem, out, priority+i*pluginMaxSegments*2, config, command, append(slices.Clip(env), "POWERGOLINE_PLUGIN_INDEX="+strconv.Itoa(i)))
+	}
+}
+
+func segmentCallOnePlugin(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config, cmd Plugin, env []string) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if !pluginAllowed(cmd, os.Getenv("PWD")) {
+		out <- Segment{Kind: PluginBox, Index: priority, Show: false}
+		return
+	}
+	start := time.Now()
+	output, stale, err := callPlugin(config, cmd, env)
+	runtime := time.Since(start)
+	if config.Debug {
+		fmt.Printf("%s ran in %s\n", cmd.Name, runtime)
+	}
+	if errors.Is(err, errEmptyOutput) {
+		// hide as there is no output to show.
+		out <- Segment{Kind: PluginBox, Index: priority, Show: false}
+		return
+	}
+	if err != nil {
+		// use error message instead.
+		output = []byte(err.Error())
+	}
+	fg, bg := pluginColors(cmd, config)
+	for k, box := range pluginSegments(output, fg, bg) {
+		if stale && k == 0 {
+			// The output of an asynchronous plugin was not refreshed recently.
+			box.Text = u0020 + u25F7 + box.Text
+		}
+		box.Index = priority + k*2
+		out <- box
+	}
+}
+
+// callPlugin returns the output of the plugin from the daemon, if running, or
+// executes the plugin. Asynchronous plugins return the cached output and true
+// if the output is stale, and the other plugins return the cached output until
+// it expires, see -plugin.cache.
+func callPlugin(config Config, cmd Plugin, env []string) ([]byte, bool, error) {
+	if cmd.Cache == 0 {
+		cmd.Cache = config.PluginCache
+	}
+	if res, err := askDaemon(config, daemonRequest{Plugin: &cmd, Dir: pluginDir(cmd), Env: env, Environ: os.Environ(), Timeout: pluginTimeout(cmd, config)}); err == nil {
+		return res.Output, false, res.err()
+	}
+	if cmd.Async {
+		return callPluginAsync(config, cmd, env)
+	}
+	if cmd.Cache > 0 {
+		output, err := callPluginCached(config, cmd, env)
+		return output, false, err
+	}
+	output, err := callIn(cmd.Dir, env, pluginTimeout(cmd, config), cmd.Name, cmd.Args...)
+	return output, false, err
+}
+
+// segmentJobs prints the number of jobs running in the background.
+func segmentJobs(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if config.JobsN <= 0 {
+		return
+	}
+	out <- Segment{Kind: TextBox, Index: priority, Show: true, Fg: config.JobsFg, Bg: config.JobsBg, Text: u0020 + u2699 + u0020 + strconv.Itoa(config.JobsN) + u0020}
+}
+
+// segmentDuration prints the execution time of the most recent program if it
+// took longer than the minimum duration.
+func segmentDuration(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	if config.DurationTime <= 0 || config.DurationTime < config.DurationMin {
+		return
+	}
+	took := config.DurationTime
+	if took < time.Minute {
+		took = took.Round(time.Millisecond * 100)
+	} else {
+		took = took.Round(time.Second)
+	}
+	out <- Segment{Kind: TextBox, Index: priority, Show: true, Fg: config.DurationFg, Bg: config.DurationBg, Text: u0020 + u231B + u0020 + took.String() + u0020}
+}
+
+// segmentExitCode prints an indicator for root users.
+//
+// System status codes:
+//
+//	> 0     - Operation success and generic status code.
+//	> 1     - Catchall for general errors and failures.
+//	> 2     - Misuse of shell builtins, missing command or permission problem.
+//	> 126   - Cannot execute command, permission problem, or not an executable.
+//	> 127   - Command not found, illegal path, or possible typo.
+//	> 128   - Invalid argument to exit, only use range 0-255.
+//	> 128+n - Fatal error signal where "n" is the PID.
+//	> 130   - Script terminated by Control-C.
+//	> 255*  - Exit status out of range.
+func segmentExitCode(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+	defer wg.Done()
+	defer func() { <-sem }()
+	var color Color
+	var symbol string
+	status := config.StatusCode
+	if os.Getuid() == 0 {
+		symbol = config.SymbolRoot
+	} else {
+		symbol = config.SymbolUser
+	}
+	if status == 0 {
+		color = config.StatusSuccess
+	} else if status == 1 {
+		color = config.StatusError
+	} else if status == 2 {
+		color = config.StatusMisuse
+	} else if status == 126 {
+		color = config.StatusCantExec
+	} else if status == 127 {
+		color = config.StatusNotFound
+	} else if status == 128 {
+		color = config.StatusInvalid
+	} else if status > 128 && status != 130 && status < 255 {
+		color = config.StatusErrSignal
+	} else if status == 130 {
+		color = config.StatusTerminated
+	} else {
+		color = config.StatusOutofrange
+	}
+	if codes := strings.Fields(config.StatusPipe); len(codes) > 1 && slices.ContainsFunc(codes, func(code string) bool { return code != "0" }) {
+		// Print the exit status of every command in the pipeline if one failed.
+		symbol = strings.Join(codes, "|") + u0020 + symbol
+	}
+	out <- Segment{Kind: ExitCodeBox, Index: priority, Show: true, Fg: config.StatusFg, Bg: color, Text: u0020 + symbol + u0020}
+}
+
+// call executes an external command and returns the output.
+func call(timeout time.Duration, name string, arg ...string) ([]byte, error) {
+	return callIn("", nil, timeout, name, arg...)
+}
+
+// callIn executes the command in the folder, or in the current working
+// directory if the folder is empty, same as call. The variables in env are
+// added to the environment of the program.
+func callIn(dir string, env []string, timeout time.Duration, name string, arg ...string) ([]byte, error) {
+	if len(env) > 0 {
+		env = append(os.Environ(), env...)
+	}
+	return callEnv(dir, env, timeout, name, arg...)
+}
+
+// callEnv executes the program with the environment, or with the environment
+// of this process if nil.
+func callEnv(dir string, env []string, timeout time.Duration, name string, arg ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.Dir = dir
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timeout after %s", name, timeout)
+		}
+		if stderr.Len() == 0 {
+			return nil, err
+		}
+		// include additional information, if possible.
+		return nil, fmt.Errorf("%s", stderr.String())
+	}
+	if stdout.Len() == 0 {
+		return nil, errEmptyOutput
+	}
+	return bytes.Trim(stdout.Bytes(), "\n"), nil
+}
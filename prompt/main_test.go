@@ -0,0 +1,2101 @@
+package prompt
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func compareRepoStatus(t *testing.T, actual RepoStatus, expected RepoStatus) {
+	if !bytes.Equal(actual.Branch, expected.Branch) {
+		t.Fatalf("unexpected status.Branch `%s` != `%s`", actual.Branch, expected.Branch)
+	}
+
+	if actual.Ahead != expected.Ahead {
+		t.Fatalf("unexpected status.Ahead %d != %d", actual.Ahead, expected.Ahead)
+	}
+
+	if actual.Behind != expected.Behind {
+		t.Fatalf("unexpected status.Behind %d != %d", actual.Behind, expected.Behind)
+	}
+
+	if actual.Added != expected.Added {
+		t.Fatalf("unexpected status.Added %d != %d", actual.Added, expected.Added)
+	}
+
+	if actual.Deleted != expected.Deleted {
+		t.Fatalf("unexpected status.Deleted %d != %d", actual.Deleted, expected.Deleted)
+	}
+
+	if actual.Modified != expected.Modified {
+		t.Fatalf("unexpected status.Modified %d != %d", actual.Modified, expected.Modified)
+	}
+
+	if actual.Staged != expected.Staged {
+		t.Fatalf("unexpected status.Staged %d != %d", actual.Staged, expected.Staged)
+	}
+
+	if actual.Unstaged != expected.Unstaged {
+		t.Fatalf("unexpected status.Unstaged %d != %d", actual.Unstaged, expected.Unstaged)
+	}
+
+	if actual.Untracked != expected.Untracked {
+		t.Fatalf("unexpected status.Untracked %d != %d", actual.Untracked, expected.Untracked)
+	}
+
+	if actual.Conflicts != expected.Conflicts {
+		t.Fatalf("unexpected status.Conflicts %d != %d", actual.Conflicts, expected.Conflicts)
+	}
+
+	if actual.Renamed != expected.Renamed {
+		t.Fatalf("unexpected status.Renamed %d != %d", actual.Renamed, expected.Renamed)
+	}
+
+	if actual.Stashed != expected.Stashed {
+		t.Fatalf("unexpected status.Stashed %d != %d", actual.Stashed, expected.Stashed)
+	}
+}
+
+func TestStatusGit(t *testing.T) {
+	lines := [][]byte{
+		[]byte("## master...origin/master"),
+		[]byte("D  deleted.txt"),
+		[]byte(" D missing.txt"),
+		[]byte("M  patches.go"),
+		[]byte(" M changes.go"),
+		[]byte("A  newfile.sh"),
+		[]byte("?? isadded.json"),
+	}
+
+	status, err := repoStatusGitParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusGitParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:    []byte("master"),
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Staged:    3,
+		Unstaged:  2,
+		Untracked: 1,
+	})
+}
+
+func TestStatusGitAhead(t *testing.T) {
+	lines := [][]byte{
+		[]byte("## master...origin/master [ahead 5]"),
+		[]byte("D  deleted.txt"),
+		[]byte(" D missing.txt"),
+		[]byte("M  patches.go"),
+		[]byte(" M changes.go"),
+		[]byte("A  newfile.sh"),
+		[]byte("?? isadded.json"),
+	}
+
+	status, err := repoStatusGitParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusGitParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:    []byte("master"),
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Staged:    3,
+		Unstaged:  2,
+		Untracked: 1,
+		Ahead:     5,
+	})
+}
+
+func TestStatusGitBehind(t *testing.T) {
+	lines := [][]byte{
+		[]byte("## master...origin/master [behind 8]"),
+		[]byte("D  deleted.txt"),
+		[]byte(" D missing.txt"),
+		[]byte("M  patches.go"),
+		[]byte(" M changes.go"),
+		[]byte("A  newfile.sh"),
+		[]byte("?? isadded.json"),
+	}
+
+	status, err := repoStatusGitParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusGitParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:    []byte("master"),
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Staged:    3,
+		Unstaged:  2,
+		Untracked: 1,
+		Behind:    8,
+	})
+}
+
+func TestStatusGitAheadBehind(t *testing.T) {
+	lines := [][]byte{
+		[]byte("## master...origin/master [ahead 5, behind 8]"),
+		[]byte("D  deleted.txt"),
+		[]byte(" D missing.txt"),
+		[]byte("M  patches.go"),
+		[]byte(" M changes.go"),
+		[]byte("A  newfile.sh"),
+		[]byte("?? isadded.json"),
+	}
+
+	status, err := repoStatusGitParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusGitParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:    []byte("master"),
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Staged:    3,
+		Unstaged:  2,
+		Untracked: 1,
+		Ahead:     5,
+		Behind:    8,
+	})
+}
+
+func TestStatusGitConflicts(t *testing.T) {
+	lines := [][]byte{
+		[]byte("## feature"),
+		[]byte("UU both-modified.go"),
+		[]byte("AA both-added.go"),
+		[]byte("DD both-deleted.go"),
+		[]byte("R  oldname.go -> newname.go"),
+		[]byte("RM moved.go -> changed.go"),
+		[]byte("AM newfile.sh"),
+		[]byte("?? isadded.json"),
+	}
+
+	status, err := repoStatusGitParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusGitParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:    []byte("feature"),
+		Modified:  1,
+		Staged:    3,
+		Unstaged:  2,
+		Untracked: 1,
+		Conflicts: 3,
+		Renamed:   2,
+	})
+}
+
+func TestRepoStatusBg(t *testing.T) {
+	cfg := Config{RepoBg: 255, RepoClean: 1, RepoDirty: 2, RepoAhead: 3, RepoBehind: 4, RepoDiverged: 5, RepoConflicted: -1}
+
+	testCases := []struct {
+		Name   string
+		Status RepoStatus
+		Color  Color
+	}{
+		{Name: "Clean", Status: RepoStatus{}, Color: 1},
+		{Name: "Dirty", Status: RepoStatus{Untracked: 1, Ahead: 1}, Color: 2},
+		{Name: "Ahead", Status: RepoStatus{Ahead: 2}, Color: 3},
+		{Name: "Behind", Status: RepoStatus{Behind: 2}, Color: 4},
+		{Name: "Diverged", Status: RepoStatus{Ahead: 1, Behind: 1}, Color: 5},
+		{Name: "ConflictedInherit", Status: RepoStatus{Conflicts: 1, Modified: 1}, Color: 255},
+	}
+
+	for _, tx := range testCases {
+		if color := repoStatusBg(tx.Status, cfg); color != tx.Color {
+			t.Fatalf("%s: repoStatusBg() = %d; expected %d", tx.Name, color, tx.Color)
+		}
+	}
+}
+
+func TestRepoEnabled(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var exclude, include FlagStringArray
+	fs.Var(&exclude, "repo.exclude", "")
+	fs.Var(&include, "repo.include", "")
+
+	if err := fs.Parse([]string{
+		"-repo.exclude=~/huge-monorepo/**",
+		"-repo.exclude=/srv/**/vendor",
+		"-repo.include=~/huge-monorepo/docs",
+		"-repo.include=~/work",
+		"-repo.include=/opt/*-tools",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(exclude) != 2 || len(include) != 3 {
+		t.Fatalf("unexpected flag values %q %q", exclude, include)
+	}
+
+	testCases := []struct {
+		Name    string
+		RepoOn  bool
+		Dir     string
+		Enabled bool
+	}{
+		{Name: "Default", RepoOn: true, Dir: "/home/user/project", Enabled: true},
+		{Name: "Disabled", RepoOn: false, Dir: "/home/user/project", Enabled: false},
+		{Name: "Included", RepoOn: false, Dir: "/home/user/work", Enabled: true},
+		{Name: "IncludedSubfolder", RepoOn: false, Dir: "/home/user/work/a/b", Enabled: true},
+		{Name: "IncludedGlob", RepoOn: false, Dir: "/opt/dev-tools/bin", Enabled: true},
+		{Name: "NotIncludedPrefix", RepoOn: false, Dir: "/home/user/workspace", Enabled: false},
+		{Name: "ExcludedRoot", RepoOn: true, Dir: "/home/user/huge-monorepo", Enabled: false},
+		{Name: "ExcludedSibling", RepoOn: true, Dir: "/home/user/huge-monorepo-docs", Enabled: true},
+		{Name: "Excluded", RepoOn: true, Dir: "/home/user/huge-monorepo/src", Enabled: false},
+		{Name: "ExcludedOverInclude", RepoOn: false, Dir: "/home/user/huge-monorepo/docs", Enabled: false},
+		{Name: "ExcludedDoubleStar", RepoOn: true, Dir: "/srv/app/lib/vendor/pkg", Enabled: false},
+	}
+
+	for _, tx := range testCases {
+		cfg := Config{RepoOn: tx.RepoOn, RepoExclude: exclude, RepoInclude: include}
+
+		if enabled := repoEnabled(cfg, tx.Dir, "/home/user"); enabled != tx.Enabled {
+			t.Fatalf("%s: repoEnabled(%q) = %t; expected %t", tx.Name, tx.Dir, enabled, tx.Enabled)
+		}
+	}
+}
+
+// fakeBackend is a RepoBackend that counts the calls to Status.
+type fakeBackend struct {
+	key   *string
+	calls *int
+}
+
+func (b fakeBackend) Detect(dir string) bool { return false }
+
+func (b fakeBackend) Branch(root string) (string, error) { return "main", nil }
+
+func (b fakeBackend) CacheKey(root string) (string, error) { return *b.key, nil }
+
+func (b fakeBackend) Status(root string) (RepoStatus, error) {
+	*b.calls++
+	return RepoStatus{Branch: []byte("main"), Modified: *b.calls}, nil
+}
+
+func TestRepoStatusCached(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	key, calls := "a", 0
+	backend := fakeBackend{key: &key, calls: &calls}
+
+	for i, expected := range []int{1, 1, 2, 2} {
+		if i == 2 {
+			key = "b"
+		}
+
+		status, err := repoStatusCached(backend, "/tmp/project", time.Minute)
+
+		if err != nil {
+			t.Fatalf("repoStatusCached %s", err)
+		}
+
+		if status.Modified != expected || string(status.Branch) != "main" {
+			t.Fatalf("call %d: unexpected status %d `%s`; expected %d", i, status.Modified, status.Branch, expected)
+		}
+	}
+
+	if status, _ := repoStatusCached(backend, "/tmp/project", 0); status.Modified != 3 {
+		t.Fatalf("expected the cache to be disabled; got %d", status.Modified)
+	}
+}
+
+func TestStatusMercurial(t *testing.T) {
+	lines := [][]byte{
+		[]byte("R deleted.txt"),
+		[]byte("! missing.txt"),
+		[]byte("M patches.go"),
+		[]byte("M changes.go"),
+		[]byte("A newfile.sh"),
+		[]byte("? isadded.json"),
+	}
+
+	status, err := repoStatusMercurialParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusMercurialParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:    []byte("default"),
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Untracked: 1,
+	})
+}
+
+func TestStatusSubversion(t *testing.T) {
+	lines := [][]byte{
+		[]byte("D       deleted.txt"),
+		[]byte("!       missing.txt"),
+		[]byte("M       patches.go"),
+		[]byte(" M      changes.go"),
+		[]byte("A  +    newfile.sh"),
+		[]byte("?       isadded.json"),
+		[]byte("C       conflict.go"),
+		[]byte("      C tree.go"),
+		[]byte("      >   local file edit, incoming file delete upon update"),
+		[]byte("I       ignored.log"),
+		[]byte("Summary of conflicts:"),
+		[]byte("  Text conflicts: 1"),
+	}
+
+	status, err := repoStatusSubversionParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusSubversionParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Untracked: 1,
+		Conflicts: 2,
+	})
+}
+
+func TestStatusSubversionBranch(t *testing.T) {
+	testCases := []struct {
+		URL    string
+		Branch string
+	}{
+		{URL: "^/trunk", Branch: "trunk"},
+		{URL: "^/trunk/src/app", Branch: "trunk"},
+		{URL: "^/branches/feature/src", Branch: "branches/feature"},
+		{URL: "^/project/tags/v1.0.0", Branch: "project/tags/v1.0.0"},
+		{URL: "^/docs/manual", Branch: "docs/manual"},
+		{URL: "^/", Branch: "^"},
+	}
+
+	for _, tx := range testCases {
+		var status RepoStatus
+
+		repoStatusSubversionBranch(&status, [][]byte{
+			[]byte("Path: ."),
+			[]byte("URL: https://svn.example.com/repos" + tx.URL[1:]),
+			[]byte("Relative URL: " + tx.URL),
+			[]byte("Revision: 1234"),
+		})
+
+		if string(status.Branch) != tx.Branch {
+			t.Fatalf("unexpected branch for %s `%s` != `%s`", tx.URL, status.Branch, tx.Branch)
+		}
+	}
+}
+
+func TestStatusJujutsu(t *testing.T) {
+	lines := [][]byte{
+		[]byte("kxryzmor"),
+		[]byte("main feature"),
+		[]byte("conflict"),
+		[]byte("M patches.go"),
+		[]byte("M changes.go"),
+		[]byte("A newfile.sh"),
+		[]byte("D deleted.txt"),
+		[]byte("R {oldname.go => newname.go}"),
+		[]byte(""),
+	}
+
+	status, err := repoStatusJujutsuParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusJujutsuParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:    []byte("kxryzmor main feature"),
+		Modified:  2,
+		Deleted:   1,
+		Added:     1,
+		Renamed:   1,
+		Conflicts: 1,
+	})
+
+	if status, err := repoStatusJujutsuParse([][]byte{[]byte("zzzzzzzz")}); err != nil || string(status.Branch) != "zzzzzzzz" {
+		t.Fatalf("unexpected status for a clean commit `%s` %v", status.Branch, err)
+	}
+
+	if _, err := repoStatusJujutsuParse([][]byte{[]byte("")}); err == nil {
+		t.Fatal("expected an error for empty output")
+	}
+}
+
+func TestStatusFossil(t *testing.T) {
+	lines := [][]byte{
+		[]byte("DELETED    deleted.txt"),
+		[]byte("MISSING    missing.txt"),
+		[]byte("EDITED     patches.go"),
+		[]byte("UPDATED_BY_MERGE changes.go"),
+		[]byte("ADDED      newfile.sh"),
+		[]byte("EXTRA      isadded.json"),
+		[]byte("RENAMED    newname.go"),
+		[]byte("CONFLICT   conflict.go"),
+		[]byte("MERGED_WITH 8f1e4c2b7d"),
+	}
+
+	status, err := repoStatusFossilParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusFossilParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Untracked: 1,
+		Renamed:   1,
+		Conflicts: 1,
+	})
+}
+
+func TestStatusBazaar(t *testing.T) {
+	lines := [][]byte{
+		[]byte("-D  deleted.txt"),
+		[]byte(" D  missing.txt"),
+		[]byte(" M  patches.go"),
+		[]byte("  * changes.sh"),
+		[]byte("+N  newfile.sh"),
+		[]byte("?   isadded.json"),
+		[]byte("R   oldname.go => newname.go"),
+		[]byte("C   conflict.go"),
+	}
+
+	status, err := repoStatusBazaarParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusBazaarParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Modified:  2,
+		Deleted:   2,
+		Added:     1,
+		Untracked: 1,
+		Renamed:   1,
+		Conflicts: 1,
+	})
+}
+
+func TestStatusGitNoOrigin(t *testing.T) {
+	lines := [][]byte{
+		[]byte("## develop"),
+	}
+
+	status, err := repoStatusGitParse(lines)
+
+	if err != nil {
+		t.Fatalf("repoStatusGitParse %s", err)
+	}
+
+	compareRepoStatus(t, status, RepoStatus{
+		Branch: []byte("develop"),
+	})
+}
+
+func TestStatusGitCorruptIndex(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+
+	if out, err := exec.Command("git", "-C", dir, "init", "-q", "-b", "main").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %s %s", err, out)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".git", "index"), []byte("garbage"), 0o644); err != nil {
+		t.Fatalf("write %s", err)
+	}
+
+	// Only unsupported features fall back to the `git status` command.
+	if _, err := repoStatusGit(dir); err == nil || !strings.Contains(err.Error(), "invalid index") {
+		t.Fatalf("expected an invalid index error; got %v", err)
+	}
+}
+
+func TestStatusGitFallback(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	worktree := filepath.Join(t.TempDir(), "worktree")
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@a", "GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@a", "GIT_CONFIG_GLOBAL=/dev/null")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s %s", args, err, out)
+		}
+	}
+
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+	git("stash", "store", "-m", "stashed", "HEAD")
+	git("worktree", "add", "-q", "-b", "feature", worktree)
+	// Repository extensions are not supported by the native reader.
+	git("config", "core.repositoryformatversion", "1")
+	git("config", "extensions.preciousObjects", "true")
+
+	status, err := repoStatusGit(worktree)
+
+	if err != nil {
+		t.Fatalf("repoStatusGit %s", err)
+	}
+
+	// The stash of a linked worktree is stored in the common folder.
+	compareRepoStatus(t, status, RepoStatus{
+		Branch:  []byte("feature"),
+		Stashed: 1,
+	})
+}
+
+func TestFindRepository(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home")
+	repo := filepath.Join(home, "project")
+	subdir := filepath.Join(repo, "src", "pkg")
+	worktree := filepath.Join(home, "worktree", "src")
+
+	for _, folder := range []string{subdir, worktree, filepath.Join(dir, "other"), filepath.Join(dir, ".hg"), filepath.Join(repo, ".git"), filepath.Join(home, "colocated", ".git"), filepath.Join(home, "colocated", ".jj"), filepath.Join(home, "bazaar", ".bzr")} {
+		if err := os.MkdirAll(folder, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(home, "worktree", ".git"), []byte("gitdir: /tmp/main/.git/worktrees/a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(home, "fossil", "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(home, "fossil", ".fslckout"), []byte{}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name string
+		Dir  string
+		Root string
+		Kind string
+	}{
+		{Name: "RepositoryRoot", Dir: repo, Root: repo, Kind: "git"},
+		{Name: "Subdirectory", Dir: subdir, Root: repo, Kind: "git"},
+		{Name: "Worktree", Dir: worktree, Root: filepath.Join(home, "worktree"), Kind: "git"},
+		{Name: "Colocated", Dir: filepath.Join(home, "colocated"), Root: filepath.Join(home, "colocated"), Kind: "jj"},
+		{Name: "Bazaar", Dir: filepath.Join(home, "bazaar"), Root: filepath.Join(home, "bazaar"), Kind: "bzr"},
+		{Name: "Fossil", Dir: filepath.Join(home, "fossil", "src"), Root: filepath.Join(home, "fossil"), Kind: "fossil"},
+		{Name: "HomeBoundary", Dir: home, Root: "", Kind: ""},
+		{Name: "OutsideHome", Dir: filepath.Join(dir, "other"), Root: dir, Kind: "hg"},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			root, kind := findRepository(tx.Dir, home)
+
+			if root != tx.Root || kind != tx.Kind {
+				t.Fatalf("unexpected repository %q (%s); expected %q (%s)", root, kind, tx.Root, tx.Kind)
+			}
+		})
+	}
+}
+
+func TestStatusGitNative(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@a", "GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@a", "GIT_CONFIG_GLOBAL=/dev/null")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s %s", args, err, out)
+		}
+	}
+	write := func(name string, data string) {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q", "-b", "main")
+	write("patches.go", "package main\n")
+	write("changes.go", "package main\n")
+	write("deleted.txt", "deleted\n")
+	write("missing.txt", "missing\n")
+	write("src/pkg/lib.go", "package pkg\n")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+	git("branch", "upstream")
+	git("commit", "-q", "--allow-empty", "-m", "ahead")
+	git("branch", "--set-upstream-to=upstream")
+	git("gc", "-q")
+	write("changes.go", "package main // stashed\n")
+	git("stash", "-q")
+	write("patches.go", "package main // staged\n")
+	write("changes.go", "package main // unstaged\n")
+	write("newfile.sh", "#!/bin/sh\n")
+	write("isadded.json", "{}\n")
+	write("newdir/a.txt", "a\n")
+	write(".gitignore", "*.log\nvendor/\n")
+	write("ignored.log", "log\n")
+	write("vendor/x", "x\n")
+	git("add", "patches.go", "newfile.sh")
+	git("add", "-f", "vendor/x")
+	write("vendor/y", "y\n")
+	git("rm", "-q", "deleted.txt")
+	git("mv", "src/pkg/lib.go", "src/lib.go")
+
+	if err := os.Remove(filepath.Join(dir, "missing.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	native, err := repoStatusGitNative(dir)
+
+	if err != nil {
+		t.Fatalf("repoStatusGitNative %s", err)
+	}
+
+	compareRepoStatus(t, native, RepoStatus{
+		Branch:    []byte("main"),
+		Ahead:     1,
+		Modified:  2,
+		Deleted:   2,
+		Added:     2,
+		Staged:    5,
+		Unstaged:  2,
+		Untracked: 3,
+		Renamed:   1,
+		Stashed:   1,
+	})
+
+	porcelain, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+
+	if err != nil {
+		t.Fatalf("git status %s", err)
+	}
+
+	untracked := 0
+
+	for _, line := range bytes.Split(porcelain, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("?? ")) {
+			untracked++
+		}
+	}
+
+	if untracked != native.Untracked {
+		t.Fatalf("unexpected untracked files %d; git reports %d:\n%s", native.Untracked, untracked, porcelain)
+	}
+
+	git("tag", "-a", "-m", "release", "v1.0.0", "HEAD~1")
+	git("checkout", "-q", "-f", "v1.0.0")
+
+	if status, err := repoStatusGitNative(dir); err != nil || string(status.Branch) != "v1.0.0" {
+		t.Fatalf("unexpected branch for tag `%s` %v", status.Branch, err)
+	}
+
+	git("checkout", "-q", "-f", "main")
+	git("checkout", "-q", "--detach")
+
+	if status, err := repoStatusGitNative(dir); err != nil || len(status.Branch) != 7 {
+		t.Fatalf("unexpected branch for detached HEAD `%s` %v", status.Branch, err)
+	}
+}
+
+func TestGitAheadBehindMerges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	date := 0
+	git := func(args ...string) string {
+		// Commits one second apart so the walk order is deterministic.
+		date++
+		timestamp := fmt.Sprintf("@%d +0000", 1600000000+date)
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@a", "GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@a", "GIT_CONFIG_GLOBAL=/dev/null", "GIT_AUTHOR_DATE="+timestamp, "GIT_COMMITTER_DATE="+timestamp)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(branch string, message string) {
+		git("checkout", "-q", branch)
+		git("commit", "-q", "--allow-empty", "-m", message)
+	}
+
+	// The upstream merged the local tip, and another branch:
+	//
+	//	upstream: c0 - u1 - u2 - m1 (main) - u3 - m2 (side) - u4
+	//	main:     c0 - c1 - c2
+	//	side:     c0 - s1
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "c0")
+	git("branch", "upstream")
+	git("branch", "side")
+	commit("main", "c1")
+	commit("main", "c2")
+	commit("upstream", "u1")
+	commit("upstream", "u2")
+	git("merge", "-q", "--no-ff", "-m", "m1", "main")
+	commit("upstream", "u3")
+	commit("side", "s1")
+	git("checkout", "-q", "upstream")
+	git("merge", "-q", "--no-ff", "-m", "m2", "side")
+	commit("upstream", "u4")
+
+	repo, err := openGitRepository(dir)
+
+	if err != nil {
+		t.Fatalf("openGitRepository %s", err)
+	}
+
+	_, local, err := repo.resolveRef("refs/heads/main")
+
+	if err != nil {
+		t.Fatalf("resolveRef %s", err)
+	}
+
+	_, remote, err := repo.resolveRef("refs/heads/upstream")
+
+	if err != nil {
+		t.Fatalf("resolveRef %s", err)
+	}
+
+	ahead, behind, err := repo.aheadBehind(local, remote)
+
+	if err != nil {
+		t.Fatalf("aheadBehind %s", err)
+	}
+
+	expected := git("rev-list", "--left-right", "--count", "main...upstream")
+
+	if actual := strconv.Itoa(ahead) + "\t" + strconv.Itoa(behind); actual != expected {
+		t.Fatalf("unexpected ahead and behind %q; git reports %q", actual, expected)
+	}
+
+	// A shallow clone of the upstream, diverged from it. The new upstream
+	// commit is older than the boundary of the clone, as if the clocks were
+	// skewed, so the walk reaches the boundary before the common history:
+	//
+	//	origin/upstream: u4 - u5
+	//	upstream:        u4 - l1 - l2
+	origin, clone := dir, filepath.Join(t.TempDir(), "clone")
+	git("clone", "-q", "--depth", "1", "--branch", "upstream", "file://"+origin, clone)
+	dir = clone
+	commit("upstream", "l1")
+	commit("upstream", "l2")
+	dir, date = origin, 0
+	commit("upstream", "u5")
+	dir = clone
+	git("fetch", "-q")
+
+	repo, err = openGitRepository(dir)
+
+	if err != nil {
+		t.Fatalf("openGitRepository %s", err)
+	}
+
+	_, local, _ = repo.resolveRef("refs/heads/upstream")
+	_, remote, _ = repo.resolveRef("refs/remotes/origin/upstream")
+	ahead, behind, err = repo.aheadBehind(local, remote)
+
+	if err != nil {
+		t.Fatalf("aheadBehind in a shallow clone %s", err)
+	}
+
+	expected = git("rev-list", "--left-right", "--count", "upstream...origin/upstream")
+
+	if actual := strconv.Itoa(ahead) + "\t" + strconv.Itoa(behind); actual != expected {
+		t.Fatalf("unexpected ahead and behind in a shallow clone %q; git reports %q", actual, expected)
+	}
+}
+
+func TestGitOperation(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Files     map[string]string
+		Operation string
+		Branch    string
+	}{
+		{Name: "Clean", Files: map[string]string{}},
+		{
+			Name:      "RebaseMerge",
+			Files:     map[string]string{"rebase-merge/msgnum": "3\n", "rebase-merge/end": "7\n", "rebase-merge/head-name": "refs/heads/feature\n"},
+			Operation: "REBASE 3/7",
+			Branch:    "feature",
+		},
+		{
+			Name:      "RebaseApply",
+			Files:     map[string]string{"rebase-apply/rebasing": "", "rebase-apply/next": "1\n", "rebase-apply/last": "2\n", "rebase-apply/head-name": "refs/heads/main\n"},
+			Operation: "REBASE 1/2",
+			Branch:    "main",
+		},
+		{Name: "Am", Files: map[string]string{"rebase-apply/applying": ""}, Operation: "AM"},
+		{Name: "Merge", Files: map[string]string{"MERGE_HEAD": ""}, Operation: "MERGING"},
+		{Name: "CherryPick", Files: map[string]string{"CHERRY_PICK_HEAD": ""}, Operation: "CHERRY-PICKING"},
+		{Name: "Revert", Files: map[string]string{"REVERT_HEAD": ""}, Operation: "REVERTING"},
+		{Name: "Bisect", Files: map[string]string{"BISECT_LOG": ""}, Operation: "BISECTING"},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			gitdir := t.TempDir()
+
+			for name, data := range tx.Files {
+				filename := filepath.Join(gitdir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			operation, branch := gitOperation(gitdir)
+
+			if operation != tx.Operation || branch != tx.Branch {
+				t.Fatalf("gitOperation() = %q, %q; expected %q, %q", operation, branch, tx.Operation, tx.Branch)
+			}
+		})
+	}
+}
+
+func TestGitIgnore(t *testing.T) {
+	patterns := parseGitIgnore("# comment\n*.log\n!keep.log\n/build/\ndocs/**/*.pdf\nvendor/\n", "")
+	patterns = append(patterns, parseGitIgnore("*.tmp\n", "src")...)
+
+	testCases := []struct {
+		Path    string
+		IsDir   bool
+		Ignored bool
+	}{
+		{Path: "debug.log", Ignored: true},
+		{Path: "src/debug.log", Ignored: true},
+		{Path: "keep.log", Ignored: false},
+		{Path: "build", IsDir: true, Ignored: true},
+		{Path: "src/build", IsDir: true, Ignored: false},
+		{Path: "build", IsDir: false, Ignored: false},
+		{Path: "docs/a/b/file.pdf", Ignored: true},
+		{Path: "docs/file.pdf", Ignored: true},
+		{Path: "src/vendor", IsDir: true, Ignored: true},
+		{Path: "src/file.tmp", Ignored: true},
+		{Path: "file.tmp", Ignored: false},
+	}
+
+	for _, tx := range testCases {
+		if ignored := gitIgnored(patterns, tx.Path, tx.IsDir); ignored != tx.Ignored {
+			t.Fatalf("gitIgnored(%q) = %t; expected %t", tx.Path, ignored, tx.Ignored)
+		}
+	}
+}
+
+func TestApplyGitDelta(t *testing.T) {
+	base := []byte("hello world")
+	// base size 11, result size 13, copy 6 bytes from offset 0, insert
+	// "there", copy 2 bytes from offset 9.
+	delta := []byte{11, 13, 0x90, 6, 5, 't', 'h', 'e', 'r', 'e', 0x91, 9, 2}
+
+	out, err := applyGitDelta(base, delta)
+
+	if err != nil {
+		t.Fatalf("applyGitDelta %s", err)
+	}
+
+	if string(out) != "hello thereld" {
+		t.Fatalf("unexpected delta result %q", out)
+	}
+}
+
+func askTestDaemon(t *testing.T, d *daemon, req daemonRequest) daemonResponse {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go d.serve(server)
+
+	if err := json.NewEncoder(client).Encode(req); err != nil {
+		t.Fatal(err)
+	}
+
+	var res daemonResponse
+
+	if err := json.NewDecoder(client).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func TestDaemon(t *testing.T) {
+	d := &daemon{repos: map[string]*daemonEntry{}, plugins: map[string]*daemonEntry{}, interval: time.Minute, idle: time.Minute}
+	dir := t.TempDir()
+	plugin := &Plugin{Name: "pwd"}
+
+	res := askTestDaemon(t, d, daemonRequest{Plugin: plugin, Dir: dir, Timeout: time.Second})
+
+	if err := res.err(); err != nil || string(res.Output) != dir {
+		t.Fatalf("unexpected plugin output %q %v", res.Output, err)
+	}
+
+	// The output is cached until the next scheduled execution.
+	d.plugins[daemonPluginKey(daemonRequest{Plugin: plugin, Dir: dir})].res.Output = []byte("cached")
+
+	if res := askTestDaemon(t, d, daemonRequest{Plugin: plugin, Dir: dir, Timeout: time.Second}); string(res.Output) != "cached" {
+		t.Fatalf("unexpected cached output %q", res.Output)
+	}
+
+	// A different state of the prompt executes the plugin again.
+	status := &Plugin{Name: "sh", Args: []string{"-c", "echo $POWERGOLINE_STATUS"}}
+
+	for _, code := range []string{"0", "1", "0"} {
+		res := askTestDaemon(t, d, daemonRequest{Plugin: status, Dir: dir, Env: []string{"POWERGOLINE_STATUS=" + code}, Timeout: time.Second})
+
+		if string(res.Output) != code {
+			t.Fatalf("unexpected output %q for status %s", res.Output, code)
+		}
+	}
+
+	// The plugins see the environment of the shell, not the daemon.
+	profile := &Plugin{Name: "sh", Args: []string{"-c", "echo ${AWS_PROFILE:-none}"}}
+
+	for _, name := range []string{"dev", "prod", ""} {
+		environ := []string{"PATH=" + os.Getenv("PATH")}
+
+		if name != "" {
+			environ = append(environ, "AWS_PROFILE="+name)
+		}
+
+		res := askTestDaemon(t, d, daemonRequest{Plugin: profile, Dir: dir, Environ: environ, Timeout: time.Second})
+
+		if expected := cmp.Or(name, "none"); string(res.Output) != expected {
+			t.Fatalf("unexpected output %q; expected %q", res.Output, expected)
+		}
+	}
+
+	if res := askTestDaemon(t, d, daemonRequest{Plugin: &Plugin{Name: "true"}, Timeout: time.Second}); !errors.Is(res.err(), errEmptyOutput) {
+		t.Fatalf("expected empty output; got %v", res.err())
+	}
+
+	if res := askTestDaemon(t, d, daemonRequest{Root: dir, Kind: "cvs"}); res.err() == nil {
+		t.Fatal("expected an error for an unknown repository kind")
+	}
+
+	if _, err := askDaemon(Config{DaemonOn: false}, daemonRequest{}); !errors.Is(err, errDaemonUnavailable) {
+		t.Fatalf("expected the daemon to be unavailable; got %v", err)
+	}
+}
+
+func compareExitCode(t *testing.T, status int, color string) {
+	var buf bytes.Buffer
+
+	a := []byte("\\[\\e[38;5;000;48;5;")
+	b := []byte("m\\] r \\[\\e[0m\\]\\[\\e[38;5;")
+	c := []byte("m\\]\ue0b0\\[\\e[0m\\] ")
+
+	NewPowergoline(Config{
+		SymbolUser:       "r",
+		SymbolRoot:       "s",
+		StatusCode:       status,
+		StatusSuccess:    0,
+		StatusError:      111,
+		StatusMisuse:     222,
+		StatusCantExec:   126,
+		StatusNotFound:   127,
+		StatusInvalid:    128,
+		StatusErrSignal:  333,
+		StatusTerminated: 130,
+		StatusOutofrange: 999,
+	}).Render(&buf, []SegmentFunc{segmentExitCode})
+
+	var expected []byte
+	expected = append(expected, a...)
+	expected = append(expected, []byte(color)...)
+	expected = append(expected, b...)
+	expected = append(expected, []byte(color)...)
+	expected = append(expected, c...)
+
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("invalid exit code output:\nExpected: `%q`\nActual:   `%q`", buf.Bytes(), expected)
+	}
+}
+
+func TestPluginSegments(t *testing.T) {
+
+	testCases := []struct {
+		Name     string
+		Output   string
+		Expected []Segment
+	}{
+		{
+			Name:     "Text",
+			Output:   "hello\nworld",
+			Expected: []Segment{{Show: true, Fg: 0, Bg: 11, Text: " hello\u2424world "}},
+		},
+		{
+			Name:     "InvalidJSON",
+			Output:   "{not json}",
+			Expected: []Segment{{Show: true, Fg: 0, Bg: 11, Text: " {not json} "}},
+		},
+		{
+			Name:     "Object",
+			Output:   `{"text": "prod", "fg": "white", "bg": 1, "bold": true}`,
+			Expected: []Segment{{Show: true, Fg: 7, Bg: 1, Text: " prod ", Bold: true}},
+		},
+		{
+			Name:     "Hide",
+			Output:   `{"text": "prod", "hide": true}`,
+			Expected: []Segment{{Show: false, Fg: 0, Bg: 11, Text: " prod "}},
+		},
+		{
+			Name:   "Segments",
+			Output: `{"segments": [{"text": "eu-west-1"}, {"text": "3 pods", "bg": 28}]}`,
+			Expected: []Segment{
+				{Show: true, Fg: 0, Bg: 11, Text: " eu-west-1 "},
+				{Show: true, Fg: 0, Bg: 28, Text: " 3 pods "},
+			},
+		},
+		{
+			Name:   "Array",
+			Output: `[{"text": "a"}, {"text": "b", "fg": -1}]`,
+			Expected: []Segment{
+				{Show: true, Fg: 0, Bg: 11, Text: " a "},
+				{Show: true, Fg: -1, Bg: 11, Text: " b "},
+			},
+		},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			segments := pluginSegments([]byte(tx.Output), 0, 11)
+
+			if len(segments) != len(tx.Expected) {
+				t.Fatalf("unexpected number of segments %d != %d", len(segments), len(tx.Expected))
+			}
+
+			for i, seg := range segments {
+				tx.Expected[i].Kind = PluginBox
+
+				if seg != tx.Expected[i] {
+					t.Fatalf("unexpected segment %d:\nExpected: %+v\nActual:   %+v", i, tx.Expected[i], seg)
+				}
+			}
+		})
+	}
+}
+
+func TestPluginOutputJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	NewPowergoline(Config{
+		Plugins:       []Plugin{{Name: "echo", Args: []string{`[{"text": "a", "bold": true}, {"text": "b"}, {"text": "c", "bg": 1}]`}}},
+		PluginFg:      0,
+		PluginBg:      11,
+		PluginTimeout: time.Second,
+	}).Render(&buf, []SegmentFunc{segmentCallPlugins})
+
+	expected := "\\[\\e[1;38;5;000;48;5;011m\\] a \\[\\e[0m\\]" +
+		"\\[\\e[48;5;011m\\]\ue0b1\\[\\e[0m\\]" +
+		"\\[\\e[38;5;000;48;5;011m\\] b \\[\\e[0m\\]" +
+		"\\[\\e[38;5;011;48;5;001m\\]\ue0b0\\[\\e[0m\\]" +
+		"\\[\\e[38;5;000;48;5;001m\\] c \\[\\e[0m\\]" +
+		"\\[\\e[38;5;001m\\]\ue0b0\\[\\e[0m\\] "
+
+	if buf.String() != expected {
+		t.Fatalf("invalid plugin output:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+	}
+}
+
+func TestPluginAsync(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("PWD", "/tmp/project")
+
+	cmd := Plugin{Name: "echo", Args: []string{"hello"}, Async: true}
+	config := Config{PluginTimeout: time.Second, PluginStale: time.Minute, PluginCachePwd: true}
+	filename := pluginCacheFile(cmd, "/tmp/project")
+
+	// Pretend another process is refreshing the cache.
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		t.Fatalf("mkdir %s", err)
+	}
+
+	if err := os.WriteFile(filename+".lock", nil, 0o600); err != nil {
+		t.Fatalf("lock %s", err)
+	}
+
+	if _, _, err := callPluginAsync(config, cmd, nil); !errors.Is(err, errEmptyOutput) {
+		t.Fatalf("expected no output before the first refresh; got %v", err)
+	}
+
+	if err := runPluginRefresh([]string{"1s", filename, "echo", "hello"}); err != nil {
+		t.Fatalf("runPluginRefresh %s", err)
+	}
+
+	if _, err := os.Stat(filename + ".lock"); !os.IsNotExist(err) {
+		t.Fatal("expected the refresh to remove the lock")
+	}
+
+	// Lock again so the test does not spawn the test binary.
+	if err := os.WriteFile(filename+".lock", nil, 0o600); err != nil {
+		t.Fatalf("lock %s", err)
+	}
+
+	output, stale, err := callPluginAsync(config, cmd, nil)
+
+	if err != nil || string(output) != "hello" || stale {
+		t.Fatalf("unexpected output `%s` %v %v", output, stale, err)
+	}
+
+	if err := writeFileAtomic(filename, pluginCacheEntry{Output: []byte("old"), Created: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("writeFileAtomic %s", err)
+	}
+
+	if output, stale, _ := callPluginAsync(config, cmd, nil); string(output) != "old" || !stale {
+		t.Fatalf("expected stale output; got `%s` %v", output, stale)
+	}
+}
+
+func TestPluginEnv(t *testing.T) {
+	var buf bytes.Buffer
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+
+	if out, err := exec.Command("git", "-C", root, "init", "-q", "-b", "main").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %s %s", err, out)
+	}
+
+	t.Setenv("PWD", root)
+	t.Setenv("COLUMNS", "120")
+
+	script := `echo "$POWERGOLINE_STATUS $POWERGOLINE_SHELL $POWERGOLINE_BRANCH $POWERGOLINE_PLUGIN_INDEX $POWERGOLINE_COLUMNS"; test -n "$POWERGOLINE_PWD" -a "$POWERGOLINE_REPO_ROOT" = "$POWERGOLINE_PWD"`
+
+	NewPowergoline(Config{
+		Plain:         true,
+		RepoOn:        true,
+		StatusCode:    3,
+		Shell:         "zsh",
+		Plugins:       []Plugin{{Name: "true"}, {Name: "sh", Args: []string{"-c", script}}},
+		PluginTimeout: time.Second,
+	}).Render(&buf, []SegmentFunc{segmentCallPlugins})
+
+	expected := " 3 zsh main 1 120  "
+
+	if buf.String() != expected {
+		t.Fatalf("invalid plugin output:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+	}
+}
+
+func TestPluginOptions(t *testing.T) {
+	var plugins FlagPluginArray
+
+	t.Setenv("HOME", "/home/user")
+
+	if err := plugins.UnmarshalJSON([]byte(`["whoami", {"command": "kubectl config current-context", "timeout": "10s", "fg": "white", "bg": 4, "cache": "1m", "dir": "~/infra", "exists": ".kube"}]`)); err != nil {
+		t.Fatalf("UnmarshalJSON %s", err)
+	}
+
+	if err := plugins.Set(`{"command": "date '+%H %M'", "async": true}`); err != nil {
+		t.Fatalf("Set %s", err)
+	}
+
+	if len(plugins) != 3 || plugins[0].Name != "whoami" || plugins[0].Fg != nil {
+		t.Fatalf("unexpected plugins %+v", plugins)
+	}
+
+	kube := plugins[1]
+
+	if kube.Name != "kubectl" || len(kube.Args) != 2 || kube.Timeout != 10*time.Second || kube.Cache != time.Minute {
+		t.Fatalf("unexpected plugin %+v", kube)
+	}
+
+	if kube.Fg == nil || *kube.Fg != 7 || kube.Bg == nil || *kube.Bg != 4 || kube.Dir != "/home/user/infra" || kube.Exists != ".kube" {
+		t.Fatalf("unexpected plugin options %+v", kube)
+	}
+
+	if !plugins[2].Async || plugins[2].Args[0] != "+%H %M" {
+		t.Fatalf("unexpected plugin %+v", plugins[2])
+	}
+
+	for _, input := range []string{`{"command": ""}`, `{"command": "date", "timeout": "soon"}`, `{"command": "date", "colour": 1}`, `{"command": "date"`} {
+		if err := plugins.Set(input); err == nil {
+			t.Fatalf("Set(%q) expected an error", input)
+		}
+	}
+}
+
+func TestPluginConditions(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "a", "b")
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir %s", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "a", ".kube"), nil, 0o644); err != nil {
+		t.Fatalf("write %s", err)
+	}
+
+	testCases := []struct {
+		Exists   string
+		Dir      string
+		Expected bool
+	}{
+		{Exists: "", Dir: root, Expected: true},
+		{Exists: ".kube", Dir: dir, Expected: true},
+		{Exists: ".kube", Dir: filepath.Join(root, "a"), Expected: true},
+		{Exists: ".kube", Dir: root, Expected: false},
+		{Exists: ".kube", Dir: "", Expected: false},
+	}
+
+	for _, tx := range testCases {
+		if allowed := pluginAllowed(Plugin{Exists: tx.Exists}, tx.Dir); allowed != tx.Expected {
+			t.Fatalf("pluginAllowed(%q, %q) = %v; expected %v", tx.Exists, tx.Dir, allowed, tx.Expected)
+		}
+	}
+}
+
+func TestPluginCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	counter := Plugin{Name: "sh", Args: []string{"-c", "echo x >> calls; wc -l < calls | tr -d ' '"}, Dir: dir, Cache: time.Minute}
+	config := Config{PluginTimeout: time.Second, PluginCachePwd: true}
+
+	for i := 0; i < 2; i++ {
+		if output, err := callPluginCached(config, counter, nil); err != nil || string(output) != "1" {
+			t.Fatalf("call %d: unexpected output `%s` %v", i, output, err)
+		}
+	}
+
+	counter.Cache = time.Nanosecond
+
+	if output, _ := callPluginCached(config, counter, nil); string(output) != "2" {
+		t.Fatalf("expected the cached output to expire; got `%s`", output)
+	}
+
+	// The output is cached per folder, unless -plugin.cache.pwd is disabled.
+	global := Plugin{Name: "sh", Args: []string{"-c", "echo x >> " + filepath.Join(dir, "global") + "; pwd"}}
+	config.PluginCache = time.Minute
+
+	for i, pwd := range []string{"/tmp", "/", "/tmp"} {
+		t.Setenv("PWD", pwd)
+
+		if output, _, _ := callPlugin(config, global, nil); len(output) == 0 {
+			t.Fatalf("call %d: expected output", i)
+		}
+	}
+
+	config.PluginCachePwd = false
+	t.Setenv("PWD", "/usr")
+
+	if output, _, _ := callPlugin(config, global, nil); len(output) == 0 {
+		t.Fatal("expected output")
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "global")); len(data) != 6 {
+		t.Fatalf("expected 3 executions; got %d", len(data)/2)
+	}
+
+	config.PluginCache = 0
+	slow := Plugin{Name: "sleep", Args: []string{"1"}, Timeout: 10 * time.Millisecond}
+
+	if _, _, err := callPlugin(config, slow, nil); err == nil || !strings.Contains(err.Error(), "timeout after 10ms") {
+		t.Fatalf("expected the plugin timeout; got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var buf bytes.Buffer
+
+	custom := ProviderFunc(func(ctx context.Context, config Config) ([]Segment, error) {
+		return []Segment{
+			{Show: true, Fg: 255, Bg: 94, Text: " a "},
+			{Show: false, Text: " hidden "},
+			{Show: true, Fg: 255, Bg: 28, Text: " b "},
+		}, nil
+	})
+
+	t.Cleanup(func() {
+		delete(segments, "test.custom")
+		delete(segments, "test.slow")
+	})
+
+	if err := Register("test.custom", custom); err != nil {
+		t.Fatalf("Register %s", err)
+	}
+
+	for _, name := range []string{"test.custom", "cwd", "", "a,b"} {
+		if err := Register(name, custom); err == nil {
+			t.Fatalf("Register(%q) expected an error", name)
+		}
+	}
+
+	slow := ProviderFunc(func(ctx context.Context, config Config) ([]Segment, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	if err := Register("test.slow", slow); err != nil {
+		t.Fatalf("Register %s", err)
+	}
+
+	arr, err := segmentsFor("test.custom,test.slow")
+
+	if err != nil {
+		t.Fatalf("segmentsFor %s", err)
+	}
+
+	NewPowergoline(Config{
+		Plain:         true,
+		PluginTimeout: 10 * time.Millisecond,
+	}).Render(&buf, arr)
+
+	expected := " a > b > test.slow timeout after 10ms  "
+
+	if buf.String() != expected {
+		t.Fatalf("invalid custom segments:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+	}
+}
+
+type countingBackend struct {
+	calls *atomic.Int32
+}
+
+func (b countingBackend) Detect(dir string) bool { return false }
+
+func (b countingBackend) Branch(root string) (string, error) { return gitBackend{}.Branch(root) }
+
+func (b countingBackend) Status(root string) (RepoStatus, error) {
+	b.calls.Add(1)
+	return gitBackend{}.Status(root)
+}
+
+func TestDaemonWatch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@a", "GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@a", "GIT_CONFIG_GLOBAL=/dev/null")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s %s", args, err, out)
+		}
+	}
+	write := func(name string, data string) {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The attributes make the status fall back to the `git status` command.
+	git("init", "-q", "-b", "main")
+	write(".gitattributes", "* text=auto\n")
+	write(".gitignore", "node_modules/\n")
+	write("main.go", "package main\n")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+	write("main.go", "package main // modified\n")
+	write("node_modules/a/index.js", "\n")
+
+	var calls atomic.Int32
+	backends := repoBackends
+	repoBackends = append(repoBackends[:len(repoBackends):len(repoBackends)], struct {
+		Kind    string
+		Backend RepoBackend
+	}{Kind: "counting", Backend: countingBackend{calls: &calls}})
+	t.Cleanup(func() { repoBackends = backends })
+
+	d := &daemon{repos: map[string]*daemonEntry{}, plugins: map[string]*daemonEntry{}, interval: time.Hour, idle: time.Hour}
+	watcher, err := newRepoWatcher(d.invalidate)
+
+	if err != nil {
+		t.Skipf("cannot watch folders: %s", err)
+	}
+
+	d.watcher = watcher
+
+	t.Cleanup(func() {
+		watcher.Remove(dir)
+		d.mu.Lock()
+		done := d.repos[dir].done
+		delete(d.repos, dir)
+		d.mu.Unlock()
+		if done != nil {
+			<-done
+		}
+	})
+
+	if res := d.repo(daemonRequest{Root: dir, Kind: "counting"}); res.Status.Modified != 1 {
+		t.Fatalf("unexpected status %+v %s", res.Status, res.Error)
+	}
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		d.mu.Lock()
+		watched := d.repos[dir].watched
+		d.mu.Unlock()
+		if watched {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected the repository to be watched")
+		}
+	}
+
+	// A refresh must not trigger another refresh, nor the ignored folders.
+	before := calls.Load()
+	write("node_modules/b/index.js", "\n")
+	time.Sleep(500 * time.Millisecond)
+
+	if after := calls.Load(); after != before {
+		t.Fatalf("unexpected refreshes %d", after-before)
+	}
+
+	write("main.go", "package main // modified again\n")
+
+	for start := time.Now(); calls.Load() == before; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("expected a refresh after a change in the worktree")
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if after := calls.Load(); after > before+2 {
+		t.Fatalf("expected at most two refreshes; got %d", after-before)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Status int
+		Color  string
+	}{
+		{Name: "ExitCode000", Status: 0, Color: "000"},
+		{Name: "ExitCode111", Status: 1, Color: "111"},
+		{Name: "ExitCode222", Status: 2, Color: "222"},
+		{Name: "ExitCode126", Status: 126, Color: "126"},
+		{Name: "ExitCode127", Status: 127, Color: "127"},
+		{Name: "ExitCode128", Status: 128, Color: "128"},
+		{Name: "ExitCode129", Status: 129, Color: "333"},
+		{Name: "ExitCode130", Status: 130, Color: "130"},
+		{Name: "ExitCode133", Status: 133, Color: "333"},
+		{Name: "ExitCode256", Status: 256, Color: "999"},
+		{Name: "ExitCodeABC", Status: 300, Color: "999"},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			compareExitCode(t, tx.Status, tx.Color)
+		})
+	}
+}
+
+func TestShellZsh(t *testing.T) {
+	var buf bytes.Buffer
+
+	NewPowergoline(Config{
+		Shell:         "zsh",
+		UserOn:        true,
+		UserFg:        255,
+		UserBg:        33,
+		Plugins:       []Plugin{{Name: "echo", Args: []string{"100%", "$HOME"}}},
+		PluginFg:      0,
+		PluginBg:      11,
+		PluginTimeout: time.Second,
+	}).Render(&buf, []SegmentFunc{segmentUsername, segmentCallPlugins})
+
+	expected := "%{\x1b[38;5;255;48;5;033m%} %n %{\x1b[0m%}" +
+		"%{\x1b[38;5;033;48;5;011m%}\ue0b0%{\x1b[0m%}" +
+		"%{\x1b[38;5;000;48;5;011m%} 100%% \\$HOME %{\x1b[0m%}" +
+		"%{\x1b[38;5;011m%}\ue0b0%{\x1b[0m%} "
+
+	if buf.String() != expected {
+		t.Fatalf("invalid zsh output:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+	}
+}
+
+func TestShellFish(t *testing.T) {
+	var buf bytes.Buffer
+
+	NewPowergoline(Config{
+		Shell:         "fish",
+		Plugins:       []Plugin{{Name: "printf", Args: []string{"100%% $HOME \\033[1m"}}},
+		PluginFg:      0,
+		PluginBg:      11,
+		PluginTimeout: time.Second,
+	}).Render(&buf, []SegmentFunc{segmentCallPlugins})
+
+	expected := "\x1b[38;5;000;48;5;011m 100% $HOME [1m \x1b[0m" +
+		"\x1b[38;5;011m\ue0b0\x1b[0m "
+
+	if buf.String() != expected {
+		t.Fatalf("invalid fish output:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+	}
+}
+
+func TestExitCodePipe(t *testing.T) {
+	var buf bytes.Buffer
+
+	NewPowergoline(Config{
+		SymbolUser: "r",
+		SymbolRoot: "r",
+		StatusFg:   255,
+		StatusCode: 0,
+		StatusPipe: "0 1 0",
+	}).Render(&buf, []SegmentFunc{segmentExitCode})
+
+	expected := "\\[\\e[38;5;255;48;5;000m\\] 0|1|0 r \\[\\e[0m\\]" +
+		"\\[\\e[38;5;000m\\]\ue0b0\\[\\e[0m\\] "
+
+	if buf.String() != expected {
+		t.Fatalf("invalid exit code output:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+	}
+}
+
+func TestInitScript(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := printInitScript(&buf, "bash", []string{"-theme=it's"}); err != nil {
+		t.Fatalf("printInitScript %s", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(`'-theme=it'\''s' "${__powergoline_args[@]}"`)) {
+		t.Fatalf("missing quoted arguments in:\n%s", buf.Bytes())
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte(`PROMPT_COMMAND="__powergoline_prompt${PROMPT_COMMAND:+;$PROMPT_COMMAND}"`)) {
+		t.Fatalf("existing PROMPT_COMMAND is not preserved in:\n%s", buf.Bytes())
+	}
+
+	if err := printInitScript(&buf, "tcsh", nil); err == nil {
+		t.Fatal("expected an error for an unsupported shell")
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	var cfg Config
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.IntVar(&cfg.CwdN, "cwd.n", 1, "")
+	fs.BoolVar(&cfg.RepoOn, "repo.on", false, "")
+	fs.StringVar(&cfg.TimeFmt, "time.fmt", "15:04", "")
+	fs.DurationVar(&cfg.PluginTimeout, "plugin.timeout", time.Second, "")
+	fs.Var(&cfg.Plugins, "plugin", "")
+
+	filename := filepath.Join(t.TempDir(), "config.json")
+	data := `{"cwd.n": 3, "repo.on": true, "time.fmt": "2006", "plugin.timeout": "2s", "plugin": ["echo hello world"]}`
+
+	if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadConfigFile(fs, filename, nil); err != nil {
+		t.Fatalf("loadConfigFile %s", err)
+	}
+
+	if err := fs.Parse([]string{"-cwd.n=5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.CwdN != 5 || !cfg.RepoOn || cfg.TimeFmt != "2006" || cfg.PluginTimeout != time.Second*2 {
+		t.Fatalf("unexpected config values %#v", cfg)
+	}
+
+	if len(cfg.Plugins) != 1 || cfg.Plugins[0].Name != "echo" || len(cfg.Plugins[0].Args) != 2 {
+		t.Fatalf("unexpected plugins %#v", cfg.Plugins)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	data := `{"cwd.n": 3, "repo.on": true, "time.fmt": "2006", "plugin": ["echo hello"], "plugin.async": ["whoami"]}`
+
+	if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name    string
+		Args    []string
+		TimeFmt string
+		Plugins []string
+		Error   bool
+	}{
+		{Name: "File", Args: []string{"-config", filename}, TimeFmt: "2006", Plugins: []string{"echo", "whoami"}},
+		{Name: "ConfigAfterValue", Args: []string{"-time.fmt", "15:04", "-config", filename}, TimeFmt: "15:04", Plugins: []string{"echo", "whoami"}},
+		{Name: "ConfigAfterBool", Args: []string{"-repo.on", "-config=" + filename}, TimeFmt: "2006", Plugins: []string{"echo", "whoami"}},
+		{Name: "PluginOverride", Args: []string{"-config", filename, "-plugin", "date"}, TimeFmt: "2006", Plugins: []string{"date"}},
+		{Name: "PluginAsyncOverride", Args: []string{"-plugin.async", "date", "-config", filename}, TimeFmt: "2006", Plugins: []string{"date"}},
+		{Name: "MissingDefault", Args: []string{"-time.fmt", "15:04"}, TimeFmt: "15:04"},
+		{Name: "MissingExplicit", Args: []string{"-config", filename + ".missing"}, Error: true},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			var cfg Config
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.IntVar(&cfg.CwdN, "cwd.n", 1, "")
+			fs.BoolVar(&cfg.RepoOn, "repo.on", false, "")
+			fs.StringVar(&cfg.TimeFmt, "time.fmt", "15:04:05", "")
+			fs.Var(&cfg.Plugins, "plugin", "")
+			fs.Var(pluginAsyncFlag{&cfg.Plugins}, "plugin.async", "")
+			fs.String("config", filepath.Join(t.TempDir(), "config.json"), "")
+
+			err := parseFlags(fs, tx.Args)
+
+			if tx.Error {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseFlags %s", err)
+			}
+
+			if cfg.TimeFmt != tx.TimeFmt {
+				t.Fatalf("unexpected time.fmt %q; expected %q", cfg.TimeFmt, tx.TimeFmt)
+			}
+
+			names := []string{}
+
+			for _, plugin := range cfg.Plugins {
+				names = append(names, plugin.Name)
+			}
+
+			if strings.Join(names, ",") != strings.Join(tx.Plugins, ",") {
+				t.Fatalf("unexpected plugins %q; expected %q", names, tx.Plugins)
+			}
+		})
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Data  string
+		Error string
+	}{
+		{Name: "UnknownKey", Data: `{"cwd.m": 3}`, Error: `unknown key "cwd.m"`},
+		{Name: "WrongType", Data: `{"cwd.n": "3"}`, Error: `invalid value for "cwd.n"`},
+		{Name: "WrongBool", Data: `{"repo.on": 1}`, Error: `invalid value for "repo.on"`},
+		{Name: "NotAnObject", Data: `[]`, Error: `cannot unmarshal array`},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			var cfg Config
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.IntVar(&cfg.CwdN, "cwd.n", 1, "")
+			fs.BoolVar(&cfg.RepoOn, "repo.on", false, "")
+
+			filename := filepath.Join(t.TempDir(), "config.json")
+
+			if err := os.WriteFile(filename, []byte(tx.Data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := loadConfigFile(fs, filename, nil)
+
+			if err == nil || !strings.Contains(err.Error(), tx.Error) {
+				t.Fatalf("unexpected error %v; expected %q", err, tx.Error)
+			}
+		})
+	}
+}
+
+func TestPluginFlag(t *testing.T) {
+	testCases := []struct {
+		Input    string
+		Expected []string
+		Failed   bool
+	}{
+		{Input: "whoami", Expected: []string{"whoami"}},
+		{Input: "date  +%H:%M ", Expected: []string{"date", "+%H:%M"}},
+		{Input: `date "+%H %M"`, Expected: []string{"date", "+%H %M"}},
+		{Input: `printf '%s\n' "a \"b\" \$c \d"`, Expected: []string{"printf", `%s\n`, `a "b" $c \d`}},
+		{Input: `ls My\ Documents ''`, Expected: []string{"ls", "My Documents", ""}},
+		{Input: `echo a"b"'c'`, Expected: []string{"echo", "abc"}},
+		{Input: "echo a\\\nb", Expected: []string{"echo", "ab"}},
+		{Input: `echo 'hello`, Failed: true},
+		{Input: `echo "hello`, Failed: true},
+		{Input: `echo hello\`, Failed: true},
+		{Input: "  ", Failed: true},
+	}
+
+	for _, tx := range testCases {
+		var plugins FlagPluginArray
+
+		err := plugins.Set(tx.Input)
+
+		if tx.Failed {
+			if err == nil {
+				t.Fatalf("Set(%q) expected an error", tx.Input)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Set(%q) %s", tx.Input, err)
+		}
+
+		actual := append([]string{plugins[0].Name}, plugins[0].Args...)
+
+		if strings.Join(actual, "\x00") != strings.Join(tx.Expected, "\x00") {
+			t.Fatalf("Set(%q) = %q; expected %q", tx.Input, actual, tx.Expected)
+		}
+	}
+}
+
+func TestThemeFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "powergoline", "themes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "powergoline", "themes", "custom.json")
+	data := `{"extends": "agnoster", "user.bg": 99, "repo.dirty": "#ff0000", "repo.on": true, "cwd.n": 4, "symbol.user": ">"}`
+
+	if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every field in the theme file must exist in the config.
+	theme := reflect.TypeFor[themeFile]()
+
+	for i := 1; i < theme.NumField(); i++ {
+		field, ok := reflect.TypeFor[Config]().FieldByName(theme.Field(i).Name)
+
+		if !ok || field.Type != theme.Field(i).Type.Elem() || field.Tag != theme.Field(i).Tag {
+			t.Fatalf("theme field %s does not match the config", theme.Field(i).Name)
+		}
+	}
+
+	for _, name := range []string{"custom", filename} {
+		applyThemeConfig, err := themeFor(name)
+
+		if err != nil {
+			t.Fatalf("themeFor %s", err)
+		}
+
+		cfg := applyThemeConfig(Config{UserFg: 1, HostBg: 2})
+
+		if cfg.UserBg != 99 || cfg.RepoDirty != colorRGB|0xff0000 || !cfg.RepoOn || cfg.CwdN != 4 || cfg.SymbolUser != ">" || !cfg.UserOn || cfg.HomeBg != 161 || cfg.UserFg != 255 || cfg.HostBg != 2 {
+			t.Fatalf("unexpected config values %#v", cfg)
+		}
+	}
+}
+
+func TestThemeFileErrors(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Data  string
+		Error string
+	}{
+		{Name: "UnknownKey", Data: `{"user.bgg": 1}`, Error: `unknown field "user.bgg"`},
+		{Name: "NotAThemeField", Data: `{"plugin.timeout": "1s"}`, Error: `unknown field "plugin.timeout"`},
+		{Name: "WrongType", Data: `{"user.on": "1"}`, Error: `cannot unmarshal string`},
+		{Name: "WrongColorType", Data: `{"user.bg": true}`, Error: `invalid color`},
+		{Name: "WrongColor", Data: `{"user.bg": "#12345"}`, Error: `invalid color "#12345"`},
+		{Name: "UnknownBase", Data: `{"extends": "foobar"}`, Error: `cannot extend unknown theme "foobar"`},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "theme.json")
+
+			if err := os.WriteFile(filename, []byte(tx.Data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := themeFor(filename)
+
+			if err == nil || !strings.Contains(err.Error(), tx.Error) {
+				t.Fatalf("unexpected error %v; expected %q", err, tx.Error)
+			}
+		})
+	}
+}
+
+func TestSegmentsOrder(t *testing.T) {
+	var buf bytes.Buffer
+
+	arr, err := segmentsFor("status, jobs")
+
+	if err != nil {
+		t.Fatalf("segmentsFor %s", err)
+	}
+
+	NewPowergoline(Config{
+		SymbolUser: "r",
+		SymbolRoot: "r",
+		StatusFg:   255,
+		JobsN:      2,
+		JobsFg:     255,
+		JobsBg:     94,
+	}).Render(&buf, arr)
+
+	expected := "\\[\\e[38;5;255;48;5;000m\\] r \\[\\e[0m\\]" +
+		"\\[\\e[38;5;000;48;5;094m\\]\ue0b0\\[\\e[0m\\]" +
+		"\\[\\e[38;5;255;48;5;094m\\] \u2699 2 \\[\\e[0m\\]" +
+		"\\[\\e[38;5;094m\\]\ue0b0\\[\\e[0m\\] "
+
+	if buf.String() != expected {
+		t.Fatalf("invalid segments output:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+	}
+
+	if _, err := segmentsFor("cwd,foobar"); err == nil {
+		t.Fatal("expected an error for an unknown segment")
+	}
+
+	if _, err := segmentsFor("cwd,repo,cwd"); err == nil {
+		t.Fatal("expected an error for a duplicate segment")
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	testCases := []struct {
+		Input  string
+		Color  Color
+		Failed bool
+	}{
+		{Input: "0", Color: 0},
+		{Input: "255", Color: 255},
+		{Input: "-1", Color: -1},
+		{Input: "red", Color: 1},
+		{Input: "BrightWhite", Color: 15},
+		{Input: "#ff8000", Color: colorRGB | 0xff8000},
+		{Input: "256", Failed: true},
+		{Input: "#ff80", Failed: true},
+		{Input: "#gg8000", Failed: true},
+		{Input: "purple", Failed: true},
+	}
+
+	for _, tx := range testCases {
+		color, err := ParseColor(tx.Input)
+
+		if tx.Failed {
+			if err == nil {
+				t.Fatalf("ParseColor(%q) expected an error", tx.Input)
+			}
+			continue
+		}
+
+		if err != nil || color != tx.Color {
+			t.Fatalf("ParseColor(%q) = %d, %v; expected %d", tx.Input, color, err, tx.Color)
+		}
+	}
+}
+
+func TestColorJSON(t *testing.T) {
+	fg, bg := Color(colorRGB|0xff0000), Color(33)
+	none := Color(-1)
+
+	for _, plugin := range []Plugin{{Name: "echo", Fg: &fg, Bg: &bg}, {Name: "echo", Fg: &none}} {
+		data, err := json.Marshal(daemonRequest{Plugin: &plugin})
+
+		if err != nil {
+			t.Fatalf("json.Marshal %s", err)
+		}
+
+		var req daemonRequest
+
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatalf("json.Unmarshal %s: %s", data, err)
+		}
+
+		if *req.Plugin.Fg != *plugin.Fg || (plugin.Bg != nil && *req.Plugin.Bg != *plugin.Bg) || (plugin.Bg == nil && req.Plugin.Bg != nil) {
+			t.Fatalf("unexpected colors after the round trip %s", data)
+		}
+	}
+}
+
+func TestColorDepth(t *testing.T) {
+	testCases := []struct {
+		Color      Color
+		Background bool
+		Depth      ColorDepth
+		Expected   string
+	}{
+		{Color: 33, Depth: Depth16, Expected: "38;5;033"},
+		{Color: 33, Depth: DepthTrueColor, Background: true, Expected: "48;5;033"},
+		{Color: colorRGB | 0xff8000, Depth: DepthTrueColor, Expected: "38;2;255;128;0"},
+		{Color: colorRGB | 0xff8000, Depth: Depth256, Expected: "38;5;208"},
+		{Color: colorRGB | 0x808080, Depth: Depth256, Background: true, Expected: "48;5;244"},
+		{Color: colorRGB | 0xff0000, Depth: Depth16, Expected: "91"},
+		{Color: colorRGB | 0x000080, Depth: Depth16, Background: true, Expected: "44"},
+	}
+
+	for _, tx := range testCases {
+		if sgr := tx.Color.sgr(tx.Background, tx.Depth); sgr != tx.Expected {
+			t.Fatalf("unexpected sequence for %s: %q != %q", tx.Color, sgr, tx.Expected)
+		}
+	}
+}
+
+func TestPlainOutput(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Config  Config
+		NoColor string
+	}{
+		{Name: "Flag", Config: Config{Plain: true}},
+		{Name: "Environment", NoColor: "1"},
+	}
+
+	for _, tx := range testCases {
+		t.Run(tx.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			t.Setenv("NO_COLOR", tx.NoColor)
+
+			tx.Config.SymbolUser = "r"
+			tx.Config.SymbolRoot = "r"
+			tx.Config.JobsN = 2
+			tx.Config.StatusPipe = "1 0"
+
+			NewPowergoline(tx.Config).Render(&buf, []SegmentFunc{segmentJobs, segmentExitCode})
+
+			expected := " jobs 2 > 1|0 r  "
+
+			if buf.String() != expected {
+				t.Fatalf("invalid plain output:\nExpected: `%q`\nActual:   `%q`", expected, buf.String())
+			}
+		})
+	}
+}
+
+func BenchmarkAll(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{
+			TimeOn:     true,
+			UserOn:     true,
+			HostOn:     true,
+			CwdN:       3,
+			RepoOn:     true,
+			Plugins:    []Plugin{{Name: "echo"}},
+			StatusCode: 0,
+		}).Render(&buf, []SegmentFunc{
+			segmentDatetime,
+			segmentUsername,
+			segmentHostname,
+			segmentDirectories,
+			segmentRepoStatus,
+			segmentCallPlugins,
+			segmentExitCode,
+		})
+	}
+}
+
+func BenchmarkDatetime(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{TimeOn: true}).Render(&buf, []SegmentFunc{segmentDatetime})
+	}
+}
+
+func BenchmarkUsername(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{UserOn: true}).Render(&buf, []SegmentFunc{segmentUsername})
+	}
+}
+
+func BenchmarkHostname(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{HostOn: true}).Render(&buf, []SegmentFunc{segmentHostname})
+	}
+}
+
+func BenchmarkDirectories(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{CwdN: 3}).Render(&buf, []SegmentFunc{segmentDirectories})
+	}
+}
+
+func BenchmarkRepoStatus(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{RepoOn: true}).Render(&buf, []SegmentFunc{segmentRepoStatus})
+	}
+}
+
+func BenchmarkCallPlugins(b *testing.B) {
+	var buf bytes.Buffer
+
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{
+			Plugins: []Plugin{
+				{Name: "echo", Args: []string{"hello"}},
+			},
+		}).Render(&buf, []SegmentFunc{
+			segmentCallPlugins,
+		})
+	}
+}
+
+func BenchmarkExitCode(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		NewPowergoline(Config{StatusCode: 0}).Render(&buf, []SegmentFunc{segmentExitCode})
+	}
+}
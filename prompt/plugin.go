@@ -0,0 +1,363 @@
+package prompt
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pluginRefreshCommand is the internal command used to refresh the cached
+// output of an asynchronous plugin in a detached process.
+const pluginRefreshCommand = "__plugin-refresh"
+
+// pluginMaxSegments is the maximum number of segments printed per plugin.
+const pluginMaxSegments = 10
+
+// pluginAsyncFlag defines asynchronous plugins in the same list as the -plugin
+// flag, so the plugins are printed in the same order as the flags.
+type pluginAsyncFlag struct {
+	plugins *FlagPluginArray
+}
+
+// Set appends an asynchronous plugin.
+func (f pluginAsyncFlag) Set(s string) error {
+	if err := f.plugins.Set(s); err != nil {
+		return err
+	}
+	(*f.plugins)[len(*f.plugins)-1].Async = true
+	return nil
+}
+
+// UnmarshalJSON appends the asynchronous plugins in a JSON array.
+func (f pluginAsyncFlag) UnmarshalJSON(data []byte) error {
+	n := len(*f.plugins)
+	if err := f.plugins.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	for i := n; i < len(*f.plugins); i++ {
+		(*f.plugins)[i].Async = true
+	}
+	return nil
+}
+
+func (f pluginAsyncFlag) String() string {
+	return ""
+}
+
+// pluginCacheEntry is the last output of a plugin saved in the cache folder.
+type pluginCacheEntry struct {
+	Output  []byte    `json:"output,omitempty"`
+	Empty   bool      `json:"empty,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// pluginCacheScope returns the folder that is part of the key of the cached
+// output of the plugin, which is empty if the output is shared by all folders.
+func pluginCacheScope(cmd Plugin, config Config) string {
+	if cmd.Dir != "" {
+		return cmd.Dir
+	}
+	perPwd := config.PluginCachePwd
+	if cmd.CachePwd != nil {
+		perPwd = *cmd.CachePwd
+	}
+	if perPwd {
+		return os.Getenv("PWD")
+	}
+	return ""
+}
+
+// pluginCacheFile returns the location of the cached output of the plugin when
+// executed in the folder.
+func pluginCacheFile(cmd Plugin, dir string) string {
+	root := cacheDir()
+	if root == "" {
+		return ""
+	}
+	sum := sha1.Sum([]byte(dir + "\x00" + cmd.Name + "\x00" + strings.Join(cmd.Args, "\x00")))
+	return filepath.Join(root, "plugins", hex.EncodeToString(sum[:])+".json")
+}
+
+// newPluginCacheEntry returns the cache entry for the result of a plugin.
+func newPluginCacheEntry(output []byte, err error) pluginCacheEntry {
+	entry := pluginCacheEntry{Output: output, Created: time.Now()}
+	if errors.Is(err, errEmptyOutput) {
+		entry.Empty = true
+	} else if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// result returns the output and the error of the plugin.
+func (e pluginCacheEntry) result() ([]byte, error) {
+	if e.Empty {
+		return nil, errEmptyOutput
+	}
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+	return e.Output, nil
+}
+
+// readPluginCache returns the cached output of the plugin.
+func readPluginCache(filename string) (pluginCacheEntry, error) {
+	var entry pluginCacheEntry
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
+
+// writePluginCache executes the plugin and saves its output in the cache.
+func writePluginCache(filename string, timeout time.Duration, cmd Plugin) error {
+	return writeFileAtomic(filename, newPluginCacheEntry(call(timeout, cmd.Name, cmd.Args...)))
+}
+
+// pluginDir returns the folder where the plugin is executed.
+func pluginDir(cmd Plugin) string {
+	if cmd.Dir != "" {
+		return cmd.Dir
+	}
+	return os.Getenv("PWD")
+}
+
+// pluginTimeout returns the maximum time to wait for the plugin.
+func pluginTimeout(cmd Plugin, config Config) time.Duration {
+	if cmd.Timeout > 0 {
+		return cmd.Timeout
+	}
+	return config.PluginTimeout
+}
+
+// pluginColors returns the default colors of the segments of the plugin.
+func pluginColors(cmd Plugin, config Config) (Color, Color) {
+	fg, bg := config.PluginFg, config.PluginBg
+	if cmd.Fg != nil {
+		fg = *cmd.Fg
+	}
+	if cmd.Bg != nil {
+		bg = *cmd.Bg
+	}
+	return fg, bg
+}
+
+// pluginAllowed returns true if the plugin has no condition, or if the file in
+// the condition exists in the folder or one of its parents.
+func pluginAllowed(cmd Plugin, dir string) bool {
+	if cmd.Exists == "" {
+		return true
+	}
+	if dir == "" {
+		return false
+	}
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		if fileExists(filepath.Join(dir, cmd.Exists)) {
+			return true
+		}
+		if dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
+
+// callPluginCached returns the cached output of the plugin, or executes the
+// plugin and saves the output if the cached one is older than the cache option.
+// The cache is keyed by the command line, the arguments and, optionally, the
+// folder, see pluginCacheScope.
+func callPluginCached(config Config, cmd Plugin, env []string) ([]byte, error) {
+	filename := pluginCacheFile(cmd, pluginCacheScope(cmd, config))
+	if entry, err := readPluginCache(filename); err == nil && time.Since(entry.Created) < cmd.Cache {
+		return entry.result()
+	}
+	output, err := callIn(cmd.Dir, env, pluginTimeout(cmd, config), cmd.Name, cmd.Args...)
+	if filename != "" {
+		_ = writeFileAtomic(filename, newPluginCacheEntry(output, err))
+	}
+	return output, err
+}
+
+// callPluginAsync returns the cached output of the plugin, and true if the
+// output is older than the -plugin.stale duration, then refreshes the cache
+// in a detached process for the next prompt, unless the output is younger than
+// the cache option. Nothing is printed until the first refresh completes.
+func callPluginAsync(config Config, cmd Plugin, env []string) ([]byte, bool, error) {
+	filename := pluginCacheFile(cmd, pluginCacheScope(cmd, config))
+	if filename == "" {
+		return nil, false, errors.New("missing cache folder")
+	}
+	entry, err := readPluginCache(filename)
+	if err != nil || time.Since(entry.Created) >= cmd.Cache {
+		refreshPluginCache(filename, pluginTimeout(cmd, config), cmd, env)
+	}
+	if err != nil {
+		return nil, false, errEmptyOutput
+	}
+	stale := config.PluginStale > 0 && time.Since(entry.Created) > config.PluginStale
+	output, err := entry.result()
+	return output, stale, err
+}
+
+// refreshPluginCache starts a detached process that executes the plugin and
+// saves the output in the cache, unless another process is doing the same.
+func refreshPluginCache(filename string, timeout time.Duration, cmd Plugin, env []string) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return
+	}
+	lock := filename + ".lock"
+	if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) < timeout+time.Second {
+		return
+	}
+	// The lock is older than the timeout; the process probably died.
+	_ = os.Remove(lock)
+	file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	file.Close()
+	self, err := os.Executable()
+	if err != nil {
+		os.Remove(lock)
+		return
+	}
+	proc := exec.Command(self, append([]string{pluginRefreshCommand, timeout.String(), filename, cmd.Name}, cmd.Args...)...)
+	proc.Dir = cmd.Dir
+	proc.Env = append(os.Environ(), env...)
+	proc.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := proc.Start(); err != nil {
+		os.Remove(lock)
+		return
+	}
+	_ = proc.Process.Release()
+}
+
+// runPluginRefresh implements the internal command that refreshes the cache.
+//
+//	powergoline __plugin-refresh TIMEOUT FILENAME NAME [ARGS...]
+func runPluginRefresh(args []string) error {
+	if len(args) < 3 {
+		return errors.New("usage: " + pluginRefreshCommand + " TIMEOUT FILENAME NAME [ARGS...]")
+	}
+	timeout, err := time.ParseDuration(args[0])
+	if err != nil {
+		return err
+	}
+	defer os.Remove(args[1] + ".lock")
+	return writePluginCache(args[1], timeout, Plugin{Name: args[2], Args: args[3:]})
+}
+
+// pluginEnv returns the environment variables that describe the prompt to the
+// plugins, so they do not need to find the same information again.
+//
+//	POWERGOLINE_STATUS     exit code of the last command, or -1
+//	POWERGOLINE_PWD        current working directory
+//	POWERGOLINE_REPO_ROOT  root folder of the repository, if any
+//	POWERGOLINE_BRANCH     current branch of the repository, if any
+//	POWERGOLINE_COLUMNS    width of the terminal, if known
+//	POWERGOLINE_SHELL      shell that prints the prompt, e.g. zsh
+//
+// segmentCallPlugins adds POWERGOLINE_PLUGIN_INDEX with the position of the
+// plugin in the list, starting from zero.
+func pluginEnv(config Config) []string {
+	pwd, home := os.Getenv("PWD"), os.Getenv("HOME")
+	var root, branch string
+	if repoEnabled(config, pwd, home) {
+		var kind string
+		if root, kind = findRepository(pwd, home); root != "" {
+			branch, _ = repoBackendFor(kind).Branch(root)
+		}
+	}
+	shell := config.Shell
+	if _, ok := shells[shell]; !ok {
+		shell = defaultShell
+	}
+	columns := ""
+	if n := terminalColumns(); n > 0 {
+		columns = strconv.Itoa(n)
+	}
+	return []string{
+		"POWERGOLINE_STATUS=" + strconv.Itoa(config.StatusCode),
+		"POWERGOLINE_PWD=" + pwd,
+		"POWERGOLINE_REPO_ROOT=" + root,
+		"POWERGOLINE_BRANCH=" + branch,
+		"POWERGOLINE_COLUMNS=" + columns,
+		"POWERGOLINE_SHELL=" + shell,
+	}
+}
+
+// terminalColumns returns the width of the terminal. The prompt is printed in
+// a command substitution, so the standard output is not the terminal, but the
+// standard error usually is.
+func terminalColumns() int {
+	if ws, err := unix.IoctlGetWinsize(int(os.Stderr.Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 {
+		return int(ws.Col)
+	}
+	n, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	return n
+}
+
+// pluginSegment is one box in the JSON output of a plugin. The colors are
+// optional and default to the colors of the plugin.
+//
+//	> {"text": "prod", "fg": "white", "bg": "red", "bold": true}
+//	> {"segments": [{"text": "eu-west-1"}, {"text": "3 pods", "bg": 28}]}
+//	> [{"text": "eu-west-1"}, {"text": "", "hide": true}]
+type pluginSegment struct {
+	Text     string          `json:"text"`
+	Fg       *Color          `json:"fg"`
+	Bg       *Color          `json:"bg"`
+	Hide     bool            `json:"hide"`
+	Bold     bool            `json:"bold"`
+	Segments []pluginSegment `json:"segments"`
+}
+
+// pluginSegments converts the output of a plugin into segments. The output is
+// either plain text, which is printed in one segment, or a JSON object or
+// array with one or more segments. The colors are the defaults of the boxes.
+func pluginSegments(output []byte, fg Color, bg Color) []Segment {
+	var boxes []pluginSegment
+	trimmed := bytes.TrimSpace(output)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var box pluginSegment
+		if json.Unmarshal(trimmed, &box) == nil {
+			boxes = append(boxes, box)
+			if len(box.Segments) > 0 {
+				boxes = box.Segments
+			}
+		}
+	} else if len(trimmed) > 0 && trimmed[0] == '[' {
+		_ = json.Unmarshal(trimmed, &boxes)
+	}
+	if boxes == nil {
+		// Plain text; represent new lines with more obvious characters.
+		text := bytes.ReplaceAll(output, []byte("\n"), []byte(u2424))
+		return []Segment{{Kind: PluginBox, Show: true, Fg: fg, Bg: bg, Text: u0020 + string(text) + u0020}}
+	}
+	var segments []Segment
+	for _, box := range boxes[:min(len(boxes), pluginMaxSegments)] {
+		seg := Segment{Kind: PluginBox, Show: !box.Hide, Fg: fg, Bg: bg, Bold: box.Bold}
+		if box.Fg != nil {
+			seg.Fg = *box.Fg
+		}
+		if box.Bg != nil {
+			seg.Bg = *box.Bg
+		}
+		seg.Text = u0020 + strings.ReplaceAll(box.Text, "\n", u2424) + u0020
+		segments = append(segments, seg)
+	}
+	return segments
+}
@@ -0,0 +1,84 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Provider computes the boxes of a custom segment. The context is canceled
+// once the -plugin.timeout duration expires, in which case the segment prints
+// the timeout instead. An error is printed in the colors of the plugins.
+//
+// The boxes are printed in order, up to ten of them, and their Index is set by
+// the renderer. Boxes with Show set to false are skipped.
+type Provider interface {
+	Segments(ctx context.Context, config Config) ([]Segment, error)
+}
+
+// ProviderFunc allows the use of ordinary functions as providers.
+type ProviderFunc func(ctx context.Context, config Config) ([]Segment, error)
+
+// Segments calls f(ctx, config).
+func (f ProviderFunc) Segments(ctx context.Context, config Config) ([]Segment, error) {
+	return f(ctx, config)
+}
+
+// Register adds a segment that can be used in the -segments flag, e.g.
+// -segments="cwd,repo,k8s,status". It returns an error if the name is not
+// valid or is already used by another segment, including the built-in ones.
+// Register is not safe for concurrent use and must be called before Main,
+// typically from an init function.
+//
+//	func init() {
+//		prompt.Register("k8s", prompt.ProviderFunc(kubernetesContext))
+//	}
+func Register(name string, p Provider) error {
+	if name == "" || strings.ContainsAny(name, ", ") {
+		return fmt.Errorf("invalid segment name %q", name)
+	}
+	if p == nil {
+		return fmt.Errorf("missing provider for segment %q", name)
+	}
+	if _, ok := segments[name]; ok {
+		return fmt.Errorf("duplicate segment %q", name)
+	}
+	segments[name] = providerSegment(name, p)
+	return nil
+}
+
+// providerSegment converts the provider into a segment function.
+func providerSegment(name string, p Provider) SegmentFunc {
+	return func(wg *sync.WaitGroup, sem chan struct{}, out chan Segment, priority int, config Config) {
+		defer wg.Done()
+		defer func() { <-sem }()
+		ctx, cancel := context.WithTimeout(context.Background(), config.PluginTimeout)
+		defer cancel()
+		type result struct {
+			boxes []Segment
+			err   error
+		}
+		done := make(chan result, 1)
+		go func() {
+			boxes, err := p.Segments(ctx, config)
+			done <- result{boxes, err}
+		}()
+		var res result
+		select {
+		case res = <-done:
+		case <-ctx.Done():
+			// Do not wait for providers that ignore the context.
+			res.err = fmt.Errorf("%s timeout after %s", name, config.PluginTimeout)
+		}
+		if res.err != nil {
+			out <- Segment{Kind: TextBox, Index: priority, Show: true, Fg: config.PluginFg, Bg: config.PluginBg, Text: u0020 + res.err.Error() + u0020}
+			return
+		}
+		// Same limit as the segments of the plugins with JSON output.
+		for k, box := range res.boxes[:min(len(res.boxes), pluginMaxSegments)] {
+			box.Index = priority + k*2
+			out <- box
+		}
+	}
+}
@@ -0,0 +1,627 @@
+package prompt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// RepoBackend is a version control system supported by the repository segment.
+// Add a new system by implementing the interface and adding an entry to the
+// repoBackends list.
+type RepoBackend interface {
+	// Detect returns true if the folder is the root of a repository.
+	Detect(dir string) bool
+	// Status returns the current state of the repository in the root folder.
+	Status(root string) (RepoStatus, error)
+	// Branch returns the name of the current branch, which is usually faster
+	// than the full status.
+	Branch(root string) (string, error)
+}
+
+// repoBackends are the supported version control systems, in the order they
+// are detected in each folder. Jujutsu is checked before Git because it is
+// usually colocated with a Git repository.
+var repoBackends = []struct {
+	Kind    string
+	Backend RepoBackend
+}{
+	{Kind: "jj", Backend: jujutsuBackend{}},
+	{Kind: "git", Backend: gitBackend{}},
+	{Kind: "hg", Backend: mercurialBackend{}},
+	{Kind: "svn", Backend: subversionBackend{}},
+	{Kind: "fossil", Backend: fossilBackend{}},
+	{Kind: "bzr", Backend: bazaarBackend{}},
+}
+
+// repoBackendFor returns the backend of a kind of repository, or nil.
+func repoBackendFor(kind string) RepoBackend {
+	for _, entry := range repoBackends {
+		if entry.Kind == kind {
+			return entry.Backend
+		}
+	}
+	return nil
+}
+
+// fileExists returns true if the file or folder exists.
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// findRepository walks up from the directory to find the root folder of the
+// closest repository and returns the root folder and the kind of repository.
+// The search stops at the root of the file system, at the home directory of
+// the current user, or when the parent folder is in a different device, e.g.
+// a network share, same as Git without GIT_DISCOVERY_ACROSS_FILESYSTEM.
+func findRepository(dir string, home string) (string, string) {
+	if dir == "" {
+		return "", ""
+	}
+	dir = filepath.Clean(dir)
+	home = filepath.Clean(home)
+	var st unix.Stat_t
+	if err := unix.Stat(dir, &st); err != nil {
+		return "", ""
+	}
+	device := uint64(st.Dev)
+	for {
+		for _, entry := range repoBackends {
+			if entry.Backend.Detect(dir) {
+				return dir, entry.Kind
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || dir == home {
+			// Reached the root of the file system or the home directory.
+			return "", ""
+		}
+		if unix.Stat(parent, &st) == nil && uint64(st.Dev) != device {
+			// Reached the mount point of a different device.
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// repoEnabled returns true if the repository segment is enabled in the folder.
+// The -repo.exclude patterns take precedence over the -repo.include patterns,
+// which take precedence over the -repo.on flag.
+func repoEnabled(config Config, dir string, home string) bool {
+	if matchFolder(config.RepoExclude, dir, home) {
+		return false
+	}
+	if matchFolder(config.RepoInclude, dir, home) {
+		return true
+	}
+	return config.RepoOn
+}
+
+// matchFolder returns true if the folder, or one of its parents, matches one
+// of the patterns. A pattern is either a path, e.g. "~/projects/foobar", or a
+// glob pattern, e.g. "~/projects/*-monorepo" or "/srv/**/vendor", with the
+// same rules as the .gitignore files. A leading tilde is the home directory.
+// A trailing "/**" also matches the folder itself, so "~/huge-monorepo/**"
+// matches the repository and everything inside it.
+func matchFolder(patterns []string, dir string, home string) bool {
+	if dir == "" {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	for _, pattern := range patterns {
+		if rest, ok := strings.CutPrefix(pattern, "~"); ok && (rest == "" || rest[0] == '/') {
+			if home == "" {
+				continue
+			}
+			pattern = home + rest
+		}
+		pattern = filepath.Clean(pattern)
+		parent, _ := strings.CutSuffix(pattern, "/**")
+		for folder := dir; ; folder = filepath.Dir(folder) {
+			if wildmatch(pattern, folder) || wildmatch(parent, folder) {
+				return true
+			}
+			if folder == filepath.Dir(folder) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// mercurialBackend is the RepoBackend for Mercurial repositories.
+type mercurialBackend struct{}
+
+// Detect returns true if the folder has a .hg folder.
+func (mercurialBackend) Detect(dir string) bool {
+	return fileExists(filepath.Join(dir, ".hg"))
+}
+
+// Status returns the state of the Mercurial repository.
+func (mercurialBackend) Status(root string) (RepoStatus, error) {
+	return repoStatusMercurial(root)
+}
+
+// Branch returns the current branch of the Mercurial repository. Mercurial
+// only writes .hg/branch for named branches.
+func (mercurialBackend) Branch(root string) (string, error) {
+	branch, err := os.ReadFile(filepath.Join(root, ".hg", "branch"))
+	if err != nil || len(bytes.TrimSpace(branch)) == 0 {
+		return "default", nil
+	}
+	return string(bytes.TrimSpace(branch)), nil
+}
+
+// subversionBackend is the RepoBackend for Subversion working copies.
+type subversionBackend struct{}
+
+// Detect returns true if the folder has a .svn folder, which only exists in
+// the root of the working copy since Subversion 1.7.
+func (subversionBackend) Detect(dir string) bool {
+	return fileExists(filepath.Join(dir, ".svn"))
+}
+
+// Status returns the state of the Subversion working copy.
+func (subversionBackend) Status(root string) (RepoStatus, error) {
+	return repoStatusSubversion(root)
+}
+
+// Branch returns the branch, tag or trunk path of the Subversion working copy.
+func (subversionBackend) Branch(root string) (string, error) {
+	info, err := call(defaultPluginTimeout, "svn", "info", root)
+	if err != nil {
+		return "", err
+	}
+	var status RepoStatus
+	repoStatusSubversionBranch(&status, bytes.Split(info, []byte("\n")))
+	return string(status.Branch), nil
+}
+
+// jujutsuBackend is the RepoBackend for Jujutsu repositories.
+type jujutsuBackend struct{}
+
+// Detect returns true if the folder has a .jj folder.
+func (jujutsuBackend) Detect(dir string) bool {
+	return fileExists(filepath.Join(dir, ".jj"))
+}
+
+// Status returns the state of the working-copy commit.
+func (jujutsuBackend) Status(root string) (RepoStatus, error) {
+	return repoStatusJujutsu(root)
+}
+
+// Branch returns the change ID and the bookmarks of the working-copy commit.
+func (jujutsuBackend) Branch(root string) (string, error) {
+	out, err := call(defaultPluginTimeout, "jj", "--repository", root, "--color", "never", "log", "--no-graph", "--revisions", "@", "--template", jujutsuBranchTemplate)
+	return string(out), err
+}
+
+// fossilBackend is the RepoBackend for Fossil checkouts.
+type fossilBackend struct{}
+
+// Detect returns true if the folder has a .fslckout file, or a _FOSSIL_ file
+// in checkouts created by older versions of Fossil or on Windows.
+func (fossilBackend) Detect(dir string) bool {
+	return fileExists(filepath.Join(dir, ".fslckout")) || fileExists(filepath.Join(dir, "_FOSSIL_"))
+}
+
+// Status returns the state of the Fossil checkout.
+func (fossilBackend) Status(root string) (RepoStatus, error) {
+	return repoStatusFossil(root)
+}
+
+// Branch returns the current branch of the Fossil checkout.
+func (fossilBackend) Branch(root string) (string, error) {
+	out, err := call(defaultPluginTimeout, "fossil", "branch", "current", "--chdir", root)
+	return string(out), err
+}
+
+// bazaarBackend is the RepoBackend for Bazaar and Breezy branches.
+type bazaarBackend struct{}
+
+// Detect returns true if the folder has a .bzr folder.
+func (bazaarBackend) Detect(dir string) bool {
+	return fileExists(filepath.Join(dir, ".bzr"))
+}
+
+// Status returns the state of the Bazaar branch.
+func (bazaarBackend) Status(root string) (RepoStatus, error) {
+	return repoStatusBazaar(root)
+}
+
+// Branch returns the nickname of the Bazaar branch, which is the name of the
+// folder unless the user sets a different one with the `bzr nick` command.
+//
+//	> nickname = feature
+//	> parent_location = ../trunk/
+func (bazaarBackend) Branch(root string) (string, error) {
+	file, err := os.Open(filepath.Join(root, ".bzr", "branch", "branch.conf"))
+	if err != nil {
+		return filepath.Base(root), nil
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.TrimSpace(key) == "nickname" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return filepath.Base(root), scanner.Err()
+}
+
+// repoStatusMercurial returns information about the current state of a Mercurial repository.
+func repoStatusMercurial(root string) (RepoStatus, error) {
+	out, err := call(defaultPluginTimeout, "hg", "--cwd", root, "status")
+
+	if err != nil && !errors.Is(err, errEmptyOutput) {
+		return RepoStatus{}, err
+	}
+
+	status, err := repoStatusMercurialParse(bytes.Split(out, []byte("\n")))
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	if branch, err := (mercurialBackend{}).Branch(root); err == nil {
+		status.Branch = []byte(branch)
+	}
+
+	return status, nil
+}
+
+// repoStatusMercurialParse parses the output of the `hg status` command.
+//
+//	> A newfile.sh
+//	> ? isadded.json
+//	> M patches.go
+//	> M changes.go
+//	> R deleted.txt
+//	> ! missing.txt
+func repoStatusMercurialParse(lines [][]byte) (RepoStatus, error) {
+	var status RepoStatus
+
+	// Mercurial only writes .hg/branch for named branches.
+	status.Branch = []byte("default")
+
+	for _, line := range lines {
+		if len(line) < 3 {
+			continue
+		}
+
+		if line[0] == 'A' {
+			status.Added++
+			continue
+		}
+
+		if line[0] == '?' {
+			status.Untracked++
+			continue
+		}
+
+		if line[0] == 'M' || line[0] == 'm' {
+			status.Modified++
+			continue
+		}
+
+		if line[0] == 'R' || line[0] == '!' {
+			status.Deleted++
+			continue
+		}
+	}
+
+	return status, nil
+}
+
+// repoStatusSubversion returns information about the current state of a Subversion working copy.
+func repoStatusSubversion(root string) (RepoStatus, error) {
+	info, err := call(defaultPluginTimeout, "svn", "info", root)
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	out, err := call(defaultPluginTimeout, "svn", "status", "--ignore-externals", root)
+
+	if err != nil && !errors.Is(err, errEmptyOutput) {
+		return RepoStatus{}, err
+	}
+
+	status, err := repoStatusSubversionParse(bytes.Split(out, []byte("\n")))
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	repoStatusSubversionBranch(&status, bytes.Split(info, []byte("\n")))
+
+	return status, nil
+}
+
+// repoStatusSubversionParse parses the output of the `svn status` command. The
+// first column is the status of the file, the second column is the status of
+// the properties, and the seventh column marks tree conflicts.
+//
+//	> A       newfile.sh
+//	> ?       isadded.json
+//	> M       patches.go
+//	>  M      changes.go
+//	> D       deleted.txt
+//	> !       missing.txt
+//	> C       conflict.go
+func repoStatusSubversionParse(lines [][]byte) (RepoStatus, error) {
+	var status RepoStatus
+
+	for _, line := range lines {
+		if len(line) < 9 || line[7] != ' ' {
+			// Skip summaries, e.g. "Performing status on external item".
+			continue
+		}
+
+		if line[0] == 'C' || line[1] == 'C' || line[6] == 'C' {
+			status.Conflicts++
+			continue
+		}
+
+		if line[0] == 'A' {
+			status.Added++
+			continue
+		}
+
+		if line[0] == '?' {
+			status.Untracked++
+			continue
+		}
+
+		if line[0] == 'D' || line[0] == '!' {
+			status.Deleted++
+			continue
+		}
+
+		if line[0] == 'M' || line[0] == 'R' || line[0] == '~' || line[1] == 'M' {
+			status.Modified++
+			continue
+		}
+	}
+
+	return status, nil
+}
+
+// repoStatusSubversionBranch parses the output of the `svn info` command and
+// uses the path relative to the root of the repository as the branch, up to
+// the name of the branch or tag if the repository uses the standard layout.
+//
+//	> Path: .
+//	> URL: https://svn.example.com/repos/project/branches/feature/src
+//	> Relative URL: ^/branches/feature/src
+//	> Repository Root: https://svn.example.com/repos/project
+//	> Revision: 1234
+func repoStatusSubversionBranch(status *RepoStatus, lines [][]byte) {
+	for _, line := range lines {
+		value, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("Relative URL: ^/"))
+		if !ok {
+			continue
+		}
+
+		parts := bytes.Split(value, []byte{'/'})
+
+		for i, part := range parts {
+			if bytes.Equal(part, []byte("trunk")) {
+				status.Branch = bytes.Join(parts[:i+1], []byte{'/'})
+				return
+			}
+
+			if (bytes.Equal(part, []byte("branches")) || bytes.Equal(part, []byte("tags"))) && i+1 < len(parts) {
+				status.Branch = bytes.Join(parts[:i+2], []byte{'/'})
+				return
+			}
+		}
+
+		status.Branch = value
+
+		if len(status.Branch) == 0 {
+			status.Branch = []byte("^")
+		}
+
+		return
+	}
+}
+
+// jujutsuTemplate prints the information of the working-copy commit, one field
+// per line, followed by the files changed in the commit.
+const jujutsuTemplate = `change_id.shortest(8) ++ "\n" ++ bookmarks.join(" ") ++ "\n" ++ if(conflict, "conflict") ++ "\n" ++ diff.summary()`
+
+// jujutsuBranchTemplate prints the change ID and the bookmarks, same as the
+// branch printed with jujutsuTemplate.
+const jujutsuBranchTemplate = `change_id.shortest(8) ++ if(bookmarks, " " ++ bookmarks.join(" "))`
+
+// repoStatusJujutsu returns information about the current state of a Jujutsu repository.
+func repoStatusJujutsu(root string) (RepoStatus, error) {
+	out, err := call(defaultPluginTimeout, "jj", "--repository", root, "--color", "never", "log", "--no-graph", "--revisions", "@", "--template", jujutsuTemplate)
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	return repoStatusJujutsuParse(bytes.Split(out, []byte("\n")))
+}
+
+// repoStatusJujutsuParse parses the output of the `jj log` command with the
+// jujutsuTemplate. Jujutsu does not have a staging area, so every change in
+// the working copy is part of the working-copy commit.
+//
+//	> kxryzmor
+//	> main feature
+//	> conflict
+//	> M patches.go
+//	> A newfile.sh
+//	> D deleted.txt
+//	> R {oldname.go => newname.go}
+func repoStatusJujutsuParse(lines [][]byte) (RepoStatus, error) {
+	var status RepoStatus
+
+	if len(lines) == 0 || len(bytes.TrimSpace(lines[0])) == 0 {
+		return status, errors.New("invalid jj output")
+	}
+
+	// The trailing empty lines are removed when the commit has no bookmarks,
+	// no conflicts and no changes.
+	lines = append(lines, nil, nil)
+
+	status.Symbol = u25C9
+	status.Branch = bytes.TrimSpace(lines[0])
+
+	if bookmarks := bytes.TrimSpace(lines[1]); len(bookmarks) > 0 {
+		status.Branch = append(append(status.Branch, ' '), bookmarks...)
+	}
+
+	if bytes.Equal(bytes.TrimSpace(lines[2]), []byte("conflict")) {
+		status.Conflicts = 1
+	}
+
+	for _, line := range lines[3:] {
+		if len(line) < 3 || line[1] != ' ' {
+			continue
+		}
+
+		switch line[0] {
+		case 'A', 'C':
+			status.Added++
+		case 'M':
+			status.Modified++
+		case 'D':
+			status.Deleted++
+		case 'R':
+			status.Renamed++
+		}
+	}
+
+	return status, nil
+}
+
+// repoStatusFossil returns information about the current state of a Fossil checkout.
+func repoStatusFossil(root string) (RepoStatus, error) {
+	out, err := call(defaultPluginTimeout, "fossil", "changes", "--differ", "--chdir", root)
+
+	if err != nil && !errors.Is(err, errEmptyOutput) {
+		return RepoStatus{}, err
+	}
+
+	status, err := repoStatusFossilParse(bytes.Split(out, []byte("\n")))
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	if branch, err := (fossilBackend{}).Branch(root); err == nil {
+		status.Branch = []byte(branch)
+	}
+
+	return status, nil
+}
+
+// repoStatusFossilParse parses the output of the `fossil changes --differ`
+// command, which includes the unmanaged files as EXTRA.
+//
+//	> ADDED      newfile.sh
+//	> EXTRA      isadded.json
+//	> EDITED     patches.go
+//	> DELETED    deleted.txt
+//	> MISSING    missing.txt
+//	> RENAMED    newname.go
+//	> CONFLICT   conflict.go
+func repoStatusFossilParse(lines [][]byte) (RepoStatus, error) {
+	var status RepoStatus
+
+	for _, line := range lines {
+		fields := bytes.Fields(line)
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch string(fields[0]) {
+		case "ADDED", "ADDED_BY_MERGE", "ADDED_BY_INTEGRATE":
+			status.Added++
+		case "EDITED", "UPDATED_BY_MERGE", "UPDATED_BY_INTEGRATE", "EXECUTABLE", "UNEXEC", "SYMLINK", "UNLINK":
+			status.Modified++
+		case "DELETED", "MISSING":
+			status.Deleted++
+		case "RENAMED":
+			status.Renamed++
+		case "CONFLICT":
+			status.Conflicts++
+		case "EXTRA":
+			status.Untracked++
+		}
+	}
+
+	return status, nil
+}
+
+// repoStatusBazaar returns information about the current state of a Bazaar branch.
+func repoStatusBazaar(root string) (RepoStatus, error) {
+	out, err := call(defaultPluginTimeout, "bzr", "status", "--short", root)
+
+	if err != nil && !errors.Is(err, errEmptyOutput) {
+		return RepoStatus{}, err
+	}
+
+	status, err := repoStatusBazaarParse(bytes.Split(out, []byte("\n")))
+
+	if err != nil {
+		return RepoStatus{}, err
+	}
+
+	if branch, err := (bazaarBackend{}).Branch(root); err == nil {
+		status.Branch = []byte(branch)
+	}
+
+	return status, nil
+}
+
+// repoStatusBazaarParse parses the output of the `bzr status --short` command.
+// The first column is the change in versioning, the second column is the
+// change in the content, and the third column is the change in the execute
+// bit of the file.
+//
+//	> +N  newfile.sh
+//	> ?   isadded.json
+//	>  M  patches.go
+//	> -D  deleted.txt
+//	>  D  missing.txt
+//	> R   oldname.go => newname.go
+//	> C   conflict.go
+func repoStatusBazaarParse(lines [][]byte) (RepoStatus, error) {
+	var status RepoStatus
+
+	for _, line := range lines {
+		if len(line) < 5 {
+			continue
+		}
+
+		switch {
+		case line[0] == 'C':
+			status.Conflicts++
+		case line[0] == '?':
+			status.Untracked++
+		case line[0] == 'R':
+			status.Renamed++
+		case line[0] == '+' || line[1] == 'N':
+			status.Added++
+		case line[0] == '-' || line[1] == 'D':
+			status.Deleted++
+		case line[1] == 'M' || line[1] == 'K' || line[2] == '*':
+			status.Modified++
+		}
+	}
+
+	return status, nil
+}
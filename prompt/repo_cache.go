@@ -0,0 +1,132 @@
+package prompt
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// repoCacheKeyer is implemented by the backends that can tell if the status of
+// a repository may have changed without computing the status, usually from the
+// modification times of the files in the metadata folder.
+type repoCacheKeyer interface {
+	// CacheKey returns a string that changes when the status may change.
+	CacheKey(root string) (string, error)
+}
+
+// repoCacheEntry is the content of a cache file.
+type repoCacheEntry struct {
+	Root    string     `json:"root"`
+	Key     string     `json:"key"`
+	Created time.Time  `json:"created"`
+	Status  RepoStatus `json:"status"`
+}
+
+// repoStatusCached returns the status of the repository from the cache if the
+// backend supports it, the cache key is the same, and the entry is not older
+// than the maximum age. Otherwise, it returns the status from the backend and
+// updates the cache. A maximum age of zero disables the cache.
+func repoStatusCached(backend RepoBackend, root string, maxAge time.Duration) (RepoStatus, error) {
+	keyer, ok := backend.(repoCacheKeyer)
+	dir := cacheDir()
+	if !ok || maxAge <= 0 || dir == "" {
+		return backend.Status(root)
+	}
+	key, err := keyer.CacheKey(root)
+	if err != nil {
+		return backend.Status(root)
+	}
+	sum := sha1.Sum([]byte(root))
+	filename := filepath.Join(dir, "repo", hex.EncodeToString(sum[:])+".json")
+	var entry repoCacheEntry
+	if data, err := os.ReadFile(filename); err == nil && json.Unmarshal(data, &entry) == nil {
+		if entry.Root == root && entry.Key == key && time.Since(entry.Created) < maxAge {
+			return entry.Status, nil
+		}
+	}
+	status, err := backend.Status(root)
+	if err != nil {
+		return status, err
+	}
+	entry = repoCacheEntry{Root: root, Key: key, Created: time.Now(), Status: status}
+	// Ignore the errors; the cache is only an optimization.
+	_ = writeFileAtomic(filename, entry)
+	return status, nil
+}
+
+// writeFileAtomic encodes the value as JSON and writes it to a temporary file
+// that then replaces the file, so other processes never read a partial file.
+func writeFileAtomic(filename string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(filename), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// CacheKey returns the modification times and sizes of the index, HEAD, the
+// files of the operations in progress, the packed references and the stash,
+// plus the modification times of the folders with the loose references and of
+// the root of the worktree. Git replaces these files instead of modifying them
+// so the times change even within the same second.
+func (gitBackend) CacheKey(root string) (string, error) {
+	gitdir, err := findGitDir(root)
+	if err != nil {
+		return "", err
+	}
+	commondir := gitCommonDir(gitdir)
+	var key strings.Builder
+	files := []string{
+		root,
+		filepath.Join(gitdir, "index"),
+		filepath.Join(gitdir, "HEAD"),
+		filepath.Join(gitdir, "MERGE_HEAD"),
+		filepath.Join(gitdir, "CHERRY_PICK_HEAD"),
+		filepath.Join(gitdir, "REVERT_HEAD"),
+		filepath.Join(gitdir, "BISECT_LOG"),
+		filepath.Join(gitdir, "rebase-merge"),
+		filepath.Join(gitdir, "rebase-apply"),
+		filepath.Join(commondir, "packed-refs"),
+		filepath.Join(commondir, "logs", "refs", "stash"),
+	}
+	for _, filename := range files {
+		if info, err := os.Stat(filename); err == nil {
+			fmt.Fprintf(&key, "%d:%d;", info.ModTime().UnixNano(), info.Size())
+		} else {
+			key.WriteString("-;")
+		}
+	}
+	err = filepath.WalkDir(filepath.Join(commondir, "refs"), func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&key, "%s:%d;", filename, info.ModTime().UnixNano())
+		return nil
+	})
+	return key.String(), err
+}
@@ -0,0 +1,113 @@
+package prompt
+
+import (
+	"os"
+	"os/user"
+	"strings"
+	"unicode"
+)
+
+// Shell describes how the prompt is formatted for a specific command line
+// interpreter. Each shell has its own way to mark non-printing characters,
+// expand the username and hostname, and interpret special characters in the
+// prompt string.
+type Shell struct {
+	// ColorStart is printed before the SGR parameters of a color sequence.
+	ColorStart string
+	// ColorEnd is printed after the SGR parameters of a color sequence.
+	ColorEnd string
+	// Username returns the text that represents the current system user.
+	Username func() string
+	// Hostname returns the text that represents the name of this system.
+	Hostname func() string
+	// Escape prevents the shell from interpreting special characters.
+	Escape func(string) string
+}
+
+// defaultShell is used when the user does not specify a shell or the shell is
+// not supported by the program.
+const defaultShell string = "bash"
+
+var shells = map[string]Shell{
+	"bash": {
+		ColorStart: "\\[\\e[",
+		ColorEnd:   "m\\]",
+		Username:   func() string { return "\\u" },
+		Hostname:   func() string { return "\\h" },
+		Escape:     escapeBash,
+	},
+	"zsh": {
+		ColorStart: "%{\x1b[",
+		ColorEnd:   "m%}",
+		Username:   func() string { return "%n" },
+		Hostname:   func() string { return "%m" },
+		Escape:     escapeZsh,
+	},
+	"fish": {
+		ColorStart: "\x1b[",
+		ColorEnd:   "m",
+		Username:   currentUsername,
+		Hostname:   currentHostname,
+		Escape:     escapeFish,
+	},
+}
+
+// shellFor returns the shell with the given name, or the default shell.
+func shellFor(name string) Shell {
+	if sh, ok := shells[name]; ok {
+		return sh
+	}
+	return shells[defaultShell]
+}
+
+// escapeBash prevents arbitrary code execution in subshell expressions.
+func escapeBash(s string) string {
+	s = strings.ReplaceAll(s, "$", "\\$")
+	s = strings.ReplaceAll(s, "`", "\\`")
+	return s
+}
+
+// escapeZsh prevents arbitrary code execution in subshell expressions when the
+// PROMPT_SUBST option is enabled, and prevents the expansion of prompt escape
+// sequences like %n or %~ when the PROMPT_PERCENT option is enabled.
+func escapeZsh(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, "$", "\\$")
+	s = strings.ReplaceAll(s, "`", "\\`")
+	return s
+}
+
+// escapeFish removes control characters from the text. Fish prints the output
+// of the fish_prompt function as is, without parameter expansion or command
+// substitution, so dollar signs and backticks are safe. However, an escape
+// character in the text of a segment would allow a plugin or a malicious
+// folder name to inject arbitrary sequences into the terminal emulator.
+func escapeFish(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// currentUsername returns the name of the current system user, e.g. root.
+func currentUsername() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// currentHostname returns the name of this system up to the first period,
+// same as the \h escape sequence in Bash and %m in Zsh.
+func currentHostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
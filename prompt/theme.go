@@ -0,0 +1,133 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// themeFile represents a color scheme defined by the user in a JSON file. The
+// keys in the file are the names of the flags that the predefined themes set,
+// the colors, the segments they enable, and the symbols, for example:
+//
+//	{
+//	  "extends": "agnoster",
+//	  "user.bg": 26,
+//	  "cwd.fg": 8,
+//	  "cwd.bg": 255
+//	}
+//
+// The other options are rejected. The fields that are not in the file are nil,
+// and keep the value of the config.
+type themeFile struct {
+	Extends          string  `json:"extends"` // optional name of a predefined theme.
+	TimeOn           *bool   `json:"time.on"`
+	UserOn           *bool   `json:"user.on"`
+	HostOn           *bool   `json:"host.on"`
+	CwdOn            *bool   `json:"cwd.on"`
+	CwdN             *int    `json:"cwd.n"`
+	RepoOn           *bool   `json:"repo.on"`
+	SymbolRoot       *string `json:"symbol.root"`
+	SymbolUser       *string `json:"symbol.user"`
+	TimeFg           *Color  `json:"time.fg"`
+	TimeBg           *Color  `json:"time.bg"`
+	UserFg           *Color  `json:"user.fg"`
+	UserBg           *Color  `json:"user.bg"`
+	HostFg           *Color  `json:"host.fg"`
+	HostBg           *Color  `json:"host.bg"`
+	HomeFg           *Color  `json:"home.fg"`
+	HomeBg           *Color  `json:"home.bg"`
+	RodirFg          *Color  `json:"rodir.fg"`
+	RodirBg          *Color  `json:"rodir.bg"`
+	CwdFg            *Color  `json:"cwd.fg"`
+	CwdBg            *Color  `json:"cwd.bg"`
+	RepoFg           *Color  `json:"repo.fg"`
+	RepoBg           *Color  `json:"repo.bg"`
+	RepoStaged       *Color  `json:"repo.staged"`
+	RepoUnstaged     *Color  `json:"repo.unstaged"`
+	RepoUntracked    *Color  `json:"repo.untracked"`
+	RepoConflicts    *Color  `json:"repo.conflicts"`
+	RepoRenamed      *Color  `json:"repo.renamed"`
+	RepoStashed      *Color  `json:"repo.stashed"`
+	RepoClean        *Color  `json:"repo.clean"`
+	RepoDirty        *Color  `json:"repo.dirty"`
+	RepoAhead        *Color  `json:"repo.ahead"`
+	RepoBehind       *Color  `json:"repo.behind"`
+	RepoDiverged     *Color  `json:"repo.diverged"`
+	RepoConflicted   *Color  `json:"repo.conflicted"`
+	PluginFg         *Color  `json:"plugin.fg"`
+	PluginBg         *Color  `json:"plugin.bg"`
+	JobsFg           *Color  `json:"jobs.fg"`
+	JobsBg           *Color  `json:"jobs.bg"`
+	DurationFg       *Color  `json:"duration.fg"`
+	DurationBg       *Color  `json:"duration.bg"`
+	StatusFg         *Color  `json:"status.fg"`
+	StatusSuccess    *Color  `json:"status.success"`
+	StatusError      *Color  `json:"status.error"`
+	StatusMisuse     *Color  `json:"status.misuse"`
+	StatusCantExec   *Color  `json:"status.cantexec"`
+	StatusNotFound   *Color  `json:"status.notfound"`
+	StatusInvalid    *Color  `json:"status.invalid"`
+	StatusErrSignal  *Color  `json:"status.errsignal"`
+	StatusTerminated *Color  `json:"status.terminated"`
+	StatusOutofrange *Color  `json:"status.outofrange"`
+}
+
+// themeFor returns the function that applies the color scheme to the config.
+// The name is either one of the predefined themes, a path to a JSON file, or
+// the name of a JSON file in the themes directory, which is located at
+// $XDG_CONFIG_HOME/powergoline/themes/NAME.json
+func themeFor(name string) (func(Config) Config, error) {
+	if applyThemeConfig, ok := themes[name]; ok {
+		return applyThemeConfig, nil
+	}
+	filename := name
+	if !strings.ContainsRune(name, os.PathSeparator) && filepath.Ext(name) != ".json" {
+		filename = filepath.Join(filepath.Dir(defaultConfigFile()), "themes", name+".json")
+	}
+	return loadThemeFile(filename)
+}
+
+// loadThemeFile reads a color scheme from a JSON file. If the file extends one
+// of the predefined themes, the predefined theme is applied first and then the
+// values in the file are applied on top of it.
+func loadThemeFile(filename string) (func(Config) Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("theme %w", err)
+	}
+	var theme themeFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&theme); err != nil {
+		return nil, fmt.Errorf("theme %s: %w", filename, err)
+	}
+	extend := func(cfg Config) Config { return cfg }
+	if theme.Extends != "" {
+		var ok bool
+		if extend, ok = themes[theme.Extends]; !ok {
+			return nil, fmt.Errorf("theme %s: cannot extend unknown theme %q", filename, theme.Extends)
+		}
+	}
+	return func(cfg Config) Config {
+		return theme.apply(extend(cfg))
+	}, nil
+}
+
+// apply sets the values defined in the theme file on top of config. Every
+// field in themeFile, except Extends, has a field with the same name in Config.
+func (t themeFile) apply(config Config) Config {
+	src := reflect.ValueOf(t)
+	dst := reflect.ValueOf(&config).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Type().Field(i)
+		if value := src.Field(i); field.Type.Kind() == reflect.Pointer && !value.IsNil() {
+			dst.FieldByName(field.Name).Set(value.Elem())
+		}
+	}
+	return config
+}
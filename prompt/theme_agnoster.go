@@ -1,4 +1,4 @@
-package main
+package prompt
 
 func ApplyAgnosterTheme(cfg Config) Config {
 	cfg.UserOn = true
@@ -12,6 +12,12 @@ func ApplyAgnosterTheme(cfg Config) Config {
 	cfg.CwdN = 2
 	cfg.CwdFg = 8
 	cfg.CwdBg = 255
+	cfg.RepoClean = 148
+	cfg.RepoDirty = 220
+	cfg.RepoAhead = 117
+	cfg.RepoBehind = 215
+	cfg.RepoDiverged = 208
+	cfg.RepoConflicted = 203
 	cfg.SymbolUser = "$"
 	cfg.SymbolRoot = "#"
 	cfg.StatusFg = 255
@@ -1,4 +1,4 @@
-package main
+package prompt
 
 func ApplyAstrocomTheme(cfg Config) Config {
 	cfg.TimeOn = true
@@ -17,6 +17,12 @@ func ApplyAstrocomTheme(cfg Config) Config {
 	cfg.CwdOn = true
 	cfg.CwdFg = 251
 	cfg.CwdBg = 238
+	cfg.RepoClean = 114
+	cfg.RepoDirty = 221
+	cfg.RepoAhead = 110
+	cfg.RepoBehind = 180
+	cfg.RepoDiverged = 173
+	cfg.RepoConflicted = 167
 	cfg.SymbolUser = "$"
 	cfg.SymbolRoot = "#"
 	cfg.StatusFg = 255
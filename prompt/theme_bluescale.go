@@ -1,4 +1,4 @@
-package main
+package prompt
 
 func ApplyBlueScaleTheme(cfg Config) Config {
 	cfg.TimeOn = true
@@ -21,6 +21,12 @@ func ApplyBlueScaleTheme(cfg Config) Config {
 	cfg.RepoOn = true
 	cfg.RepoFg = 255
 	cfg.RepoBg = 75
+	cfg.RepoClean = 75
+	cfg.RepoDirty = 68
+	cfg.RepoAhead = 33
+	cfg.RepoBehind = 27
+	cfg.RepoDiverged = 20
+	cfg.RepoConflicted = 124
 	cfg.SymbolUser = "$"
 	cfg.SymbolRoot = "#"
 	cfg.StatusFg = 0
@@ -1,4 +1,4 @@
-package main
+package prompt
 
 func ApplyColorishTheme(cfg Config) Config {
 	cfg.TimeOn = true
@@ -21,6 +21,12 @@ func ApplyColorishTheme(cfg Config) Config {
 	cfg.RepoOn = true
 	cfg.RepoFg = 0
 	cfg.RepoBg = 148
+	cfg.RepoClean = 148
+	cfg.RepoDirty = 214
+	cfg.RepoAhead = 117
+	cfg.RepoBehind = 183
+	cfg.RepoDiverged = 209
+	cfg.RepoConflicted = 203
 	cfg.SymbolUser = "$"
 	cfg.SymbolRoot = "#"
 	cfg.StatusFg = 255
@@ -1,4 +1,4 @@
-package main
+package prompt
 
 func ApplyGrayScaleTheme(cfg Config) Config {
 	cfg.TimeOn = true
@@ -20,6 +20,12 @@ func ApplyGrayScaleTheme(cfg Config) Config {
 	cfg.RepoOn = true
 	cfg.RepoFg = 255
 	cfg.RepoBg = 247
+	cfg.RepoClean = 247
+	cfg.RepoDirty = 243
+	cfg.RepoAhead = 241
+	cfg.RepoBehind = 239
+	cfg.RepoDiverged = 237
+	cfg.RepoConflicted = 235
 	cfg.SymbolUser = "$"
 	cfg.SymbolRoot = "#"
 	cfg.StatusFg = 255
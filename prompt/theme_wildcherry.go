@@ -1,4 +1,4 @@
-package main
+package prompt
 
 func ApplyWildCherryTheme(cfg Config) Config {
 	cfg.HomeFg = 255
@@ -11,6 +11,12 @@ func ApplyWildCherryTheme(cfg Config) Config {
 	cfg.RepoOn = true
 	cfg.RepoFg = 0
 	cfg.RepoBg = 255
+	cfg.RepoClean = 255
+	cfg.RepoDirty = 218
+	cfg.RepoAhead = 225
+	cfg.RepoBehind = 224
+	cfg.RepoDiverged = 211
+	cfg.RepoConflicted = 204
 	cfg.SymbolUser = "$"
 	cfg.SymbolRoot = "#"
 	cfg.StatusFg = 255
@@ -0,0 +1,213 @@
+//go:build linux
+
+package prompt
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// repoWatchMask are the inotify events that may change the repository status.
+const repoWatchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ATTRIB | unix.IN_DELETE_SELF
+
+// repoWatcher watches the folders of the repositories with inotify and calls
+// the function with the root folder of the repository that changed, and false
+// if one of the files cannot be watched anymore.
+type repoWatcher struct {
+	fd       int
+	onChange func(root string, watched bool)
+	mu       sync.Mutex
+	roots    map[int]string
+	paths    map[int]string
+	files    map[int]bool
+	skips    map[string]func(string) bool
+	watches  map[string][]int
+}
+
+// newRepoWatcher creates an inotify instance and starts reading the events.
+func newRepoWatcher(onChange func(root string, watched bool)) (*repoWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	w := &repoWatcher{
+		fd:       fd,
+		onChange: onChange,
+		roots:    map[int]string{},
+		paths:    map[int]string{},
+		files:    map[int]bool{},
+		skips:    map[string]func(string) bool{},
+		watches:  map[string][]int{},
+	}
+	go w.read()
+	return w, nil
+}
+
+// Add watches the folders and files of the repository and returns true if all
+// of them are watched.
+func (w *repoWatcher) Add(root string) bool {
+	skip := repoWatchSkip(root)
+	w.mu.Lock()
+	w.skips[root] = skip
+	w.mu.Unlock()
+	paths, ok := repoWatchPaths(root, daemonMaxWatches, skip)
+	for _, name := range paths {
+		if !w.addPath(root, name) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// addPath watches one folder or file of the repository.
+func (w *repoWatcher) addPath(root string, name string) bool {
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	wd, err := unix.InotifyAddWatch(w.fd, name, repoWatchMask)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.roots[wd]; !ok {
+		w.watches[root] = append(w.watches[root], wd)
+	}
+	w.roots[wd] = root
+	w.paths[wd] = name
+	w.files[wd] = !info.IsDir()
+	return true
+}
+
+// forget removes a watch that the kernel removed. The caller must hold the
+// lock.
+func (w *repoWatcher) forget(wd int) {
+	root := w.roots[wd]
+	delete(w.roots, wd)
+	delete(w.paths, wd)
+	delete(w.files, wd)
+	watches := w.watches[root]
+	for i, other := range watches {
+		if other == wd {
+			w.watches[root] = append(watches[:i], watches[i+1:]...)
+			break
+		}
+	}
+}
+
+// Remove stops watching the folders of the repository.
+func (w *repoWatcher) Remove(root string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, wd := range w.watches[root] {
+		_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.roots, wd)
+		delete(w.paths, wd)
+		delete(w.files, wd)
+	}
+	delete(w.watches, root)
+	delete(w.skips, root)
+}
+
+// read parses the events and notifies the changes. New folders are watched
+// too, so the files created inside them are noticed, unless Git ignores them.
+// The events of lock files are skipped, e.g. refs/heads/main.lock, because
+// Git renames them once the changes are complete.
+//
+// If the queue of events overflows, every repository is reported as changed
+// and not watched.
+//
+// Git replaces files like HEAD and the index with a rename, so the watch of a
+// file is moved to the new file after every event.
+//
+//	struct inotify_event {
+//	    int      wd;
+//	    uint32_t mask;
+//	    uint32_t cookie;
+//	    uint32_t len;
+//	    char     name[];
+//	};
+func (w *repoWatcher) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			return
+		}
+		changed := map[string]bool{}
+		lost := map[string]bool{}
+		files := map[string]string{}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[offset:])))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			size := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			name := buf[offset+unix.SizeofInotifyEvent : min(offset+unix.SizeofInotifyEvent+size, n)]
+			offset += unix.SizeofInotifyEvent + size
+			if end := indexNull(name); end < len(name) {
+				name = name[:end]
+			}
+			if mask&unix.IN_Q_OVERFLOW != 0 {
+				// The kernel dropped events, including the creation of folders
+				// that are not watched yet, so every repository is refreshed
+				// and no longer trusted to be up to date.
+				w.mu.Lock()
+				for root := range w.watches {
+					changed[root] = true
+					lost[root] = true
+				}
+				w.mu.Unlock()
+				continue
+			}
+			w.mu.Lock()
+			root, ok := w.roots[wd]
+			dir := w.paths[wd]
+			file := w.files[wd]
+			skip := w.skips[root]
+			if ok && mask&unix.IN_IGNORED != 0 {
+				w.forget(wd)
+			}
+			w.mu.Unlock()
+			if !ok || strings.HasSuffix(string(name), ".lock") {
+				continue
+			}
+			changed[root] = true
+			if file {
+				files[dir] = root
+				continue
+			}
+			if mask&unix.IN_CREATE != 0 && mask&unix.IN_ISDIR != 0 && len(name) > 0 && string(name) != ".git" {
+				child := filepath.Join(dir, string(name))
+				if skip == nil || !skip(child) {
+					w.addPath(root, child)
+				}
+			}
+		}
+		for name, root := range files {
+			if !w.addPath(root, name) {
+				lost[root] = true
+			}
+		}
+		for root := range changed {
+			w.onChange(root, !lost[root])
+		}
+	}
+}
+
+// indexNull returns the length of the NUL-terminated name.
+func indexNull(name []byte) int {
+	for i, c := range name {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(name)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package prompt
+
+import "errors"
+
+// repoWatcher is not supported on this platform; the daemon refreshes the
+// repositories periodically instead.
+type repoWatcher struct{}
+
+// newRepoWatcher returns an error because inotify is only available on Linux.
+func newRepoWatcher(onChange func(root string, watched bool)) (*repoWatcher, error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+// Add does nothing and returns false.
+func (w *repoWatcher) Add(root string) bool {
+	return false
+}
+
+// Remove does nothing.
+func (w *repoWatcher) Remove(root string) {}